@@ -0,0 +1,147 @@
+package things
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCronSyntax is returned by ParseCron for invalid expressions.
+var ErrCronSyntax = errors.New("invalid cron expression")
+
+// KeyField is set on ErrCronSyntax errors to the invalid field.
+const KeyField ErrKey = "field"
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Cron is a parsed cron expression.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bit sets of allowed values
+	domStar, dowStar              bool
+}
+
+// ParseCron parses a standard five field cron expression;
+// minute, hour, day of month, month and day of week.
+// Fields can be *, a value, a range such as 1-5, a list such as 1,3,5, and steps such as */15 or 1-30/2.
+// Sunday is 0 or 7. The macros @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are also supported.
+// As with cron, if both day of month and day of week are restricted, a time matching either is used.
+func ParseCron(expr string) (*Cron, error) {
+	if m, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = m
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		err := ErrCronSyntax
+		ErrSet(&err, KeyField, expr)
+		return nil, err
+	}
+
+	c := &Cron{}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [5]*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+		*sets[i] = set
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = strings.HasPrefix(fields[2], "*")
+	c.dowStar = strings.HasPrefix(fields[4], "*")
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (set uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, cronFieldError(field)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.IndexByte(rng, '-') >= 0:
+			i := strings.IndexByte(rng, '-')
+			lo, err = strconv.Atoi(rng[:i])
+			if err == nil {
+				hi, err = strconv.Atoi(rng[i+1:])
+			}
+		default:
+			lo, err = strconv.Atoi(rng)
+			hi = lo
+			if step > 1 {
+				hi = max
+			}
+		}
+		if err != nil || lo < min || hi > max || lo > hi {
+			return 0, cronFieldError(field)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func cronFieldError(field string) error {
+	err := ErrCronSyntax
+	ErrSet(&err, KeyField, field)
+	return err
+}
+
+// Next returns the first time after t that matches the expression, in t's location.
+// It returns the zero time if there is no such time in the next five years.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	loc := t.Location()
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.day(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (c *Cron) day(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,44 @@
+package things
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron_Next(t *testing.T) {
+	// Thursday
+	from := time.Date(2019, time.August, 8, 10, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2019, time.August, 8, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2019, time.August, 8, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2019, time.August, 8, 13, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2019, time.August, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1,7", time.Date(2019, time.August, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 4", time.Date(2019, time.August, 15, 0, 0, 0, 0, time.UTC)},
+		{"30 6 29 2 *", time.Date(2020, time.February, 29, 6, 30, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron() = %v", err)
+			}
+			if got := c.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); !ErrIs(err, ErrCronSyntax) {
+			t.Errorf("ParseCron(%q) = %v, wanted %v", expr, err, ErrCronSyntax)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/stewi1014/things
 
-go 1.12
+go 1.18
 
 require (
 	github.com/chewxy/math32 v1.0.0
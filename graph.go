@@ -0,0 +1,319 @@
+package things
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Errors returned by Graph.
+var (
+	ErrCycle             = errors.New("dependency cycle")
+	ErrUnknownDependency = errors.New("unknown dependency")
+	ErrDuplicateNode     = errors.New("duplicate node")
+	ErrGraphStarted      = errors.New("graph already started")
+)
+
+// Keys set on errors returned by Graph.
+const (
+	// KeyNode is the name of the node the error relates to.
+	KeyNode ErrKey = "node"
+
+	// KeyDependency is the name of the dependency the error relates to.
+	KeyDependency ErrKey = "dependency"
+
+	// KeyCycle is the path of a dependency cycle as a []string, starting and ending with the same node.
+	KeyCycle ErrKey = "cycle"
+)
+
+// NewGraph creates a new, empty Graph.
+// Calling new(Graph) or Graph{} is sufficent.
+func NewGraph() *Graph {
+	return &Graph{}
+}
+
+// Graph is a set of named tasks with dependencies between them, which are executed on a Queue.
+// A node is added to the queue once all of its dependencies have succeeded,
+// so independent nodes can be executed concurrently by the queue's runners.
+//
+// Errors returned by nodes don't halt the queue, nor do panics if the queue recovers them, in which case the node fails with ErrPanic.
+// Instead, the nodes that depend on a failed node, directly or not, are skipped.
+// A node removed from the queue without being executed, such as by Queue.Reset, is skipped along with the nodes depending on it.
+// Nodes become ready while executing on the queue's runners, so they are added regardless of the queue's capacity.
+type Graph struct {
+	mutex   sync.Mutex
+	nodes   map[string]*graphNode
+	order   []*graphNode // insertion order
+	queue   *Queue
+	pending int // unresolved nodes
+	errs    Errors
+	done    chan struct{}
+}
+
+type graphNode struct {
+	name       string
+	f          func() error
+	deps       []string
+	dependents []*graphNode
+	waiting    int // unfinished dependencies
+	state      TaskState
+}
+
+// Add adds a task named name that is executed after the named dependencies have succeeded.
+// Dependencies don't have to be added before the nodes depending on them, but must be added before Start.
+func (g *Graph) Add(name string, f func() error, deps ...string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	var err error
+	if g.queue != nil {
+		err = ErrGraphStarted
+		ErrSet(&err, KeyNode, name)
+		return err
+	}
+	if _, ok := g.nodes[name]; ok {
+		err = ErrDuplicateNode
+		ErrSet(&err, KeyNode, name)
+		return err
+	}
+
+	if g.nodes == nil {
+		g.nodes = make(map[string]*graphNode)
+	}
+	n := &graphNode{
+		name: name,
+		f:    f,
+		deps: deps,
+	}
+	g.nodes[name] = n
+	g.order = append(g.order, n)
+	return nil
+}
+
+// Start checks the graph and adds the nodes without dependencies to q.
+// It returns an error with KeyNode and KeyDependency set if a dependency doesn't exist,
+// or ErrCycle with KeyCycle set if there is a dependency cycle, in which case nothing is added to q.
+func (g *Graph) Start(q *Queue) error {
+	g.mutex.Lock()
+	if g.queue != nil {
+		g.mutex.Unlock()
+		return ErrGraphStarted
+	}
+
+	for _, n := range g.order {
+		n.dependents, n.waiting = nil, 0
+	}
+	for _, n := range g.order {
+		for _, name := range n.deps {
+			dep, ok := g.nodes[name]
+			if !ok {
+				g.mutex.Unlock()
+				err := ErrUnknownDependency
+				ErrSet(&err, KeyNode, n.name)
+				ErrSet(&err, KeyDependency, name)
+				return err
+			}
+			dep.dependents = append(dep.dependents, n)
+			n.waiting++
+		}
+	}
+
+	if cycle := g.cycle(); cycle != nil {
+		g.mutex.Unlock()
+		err := ErrCycle
+		ErrSet(&err, KeyCycle, cycle)
+		return err
+	}
+
+	g.queue = q
+	g.pending = len(g.order)
+	if g.done == nil {
+		g.done = make(chan struct{})
+	}
+	if g.pending == 0 {
+		close(g.done)
+	}
+
+	var ready []*graphNode
+	for _, n := range g.order {
+		if n.waiting == 0 {
+			ready = append(ready, n)
+		}
+	}
+	g.mutex.Unlock()
+
+	g.add(addWait, ready)
+	return nil
+}
+
+// State returns the state of the named node, and false if it doesn't exist.
+// Nodes waiting for dependencies are TaskQueued, and nodes that will never run due to a failed dependency are TaskSkipped.
+func (g *Graph) State(name string) (TaskState, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	n, ok := g.nodes[name]
+	if !ok {
+		return 0, false
+	}
+	return n.state, true
+}
+
+// Wait blocks until the graph is started and every node has succeeded, failed or been skipped, or ctx finishes.
+// It returns the errors of failed nodes as Errors.Get would, each with KeyNode set, or the context's error.
+// A nil context is valid.
+func (g *Graph) Wait(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	g.mutex.Lock()
+	if g.done == nil {
+		g.done = make(chan struct{})
+	}
+	done := g.done
+	g.mutex.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	g.mutex.Lock()
+	err := g.errs.Get()
+	g.mutex.Unlock()
+	return err
+}
+
+// adds ready nodes to the queue.
+func (g *Graph) add(mode addMode, nodes []*graphNode) {
+	g.queue.insert(nil, mode, TaskOptions{}, len(nodes), func(buff []task) {
+		for i := range buff {
+			n := nodes[i]
+			buff[i].f = g.task(n)
+			buff[i].extend().onDone = func(s TaskState) {
+				if s != TaskSkipped {
+					return
+				}
+				g.mutex.Lock()
+				if n.state == TaskQueued {
+					n.state = TaskSkipped
+					g.resolve()
+					g.skip(n)
+				}
+				g.mutex.Unlock()
+			}
+		}
+	})
+}
+
+// task returns the function added to the queue for n.
+func (g *Graph) task(n *graphNode) func() error {
+	return func() error {
+		g.mutex.Lock()
+		n.state = TaskRunning
+		g.mutex.Unlock()
+
+		g.queue.mutex.Lock()
+		catch := g.queue.catch
+		g.queue.mutex.Unlock()
+
+		var err error
+		if catch {
+			t := task{f: n.f}
+			err = t.catch(nil)
+		} else {
+			err = n.f()
+		}
+
+		g.mutex.Lock()
+		var ready []*graphNode
+		if err != nil {
+			ErrSet(&err, KeyNode, n.name)
+			g.errs = append(g.errs, err)
+			n.state = TaskFailed
+			g.skip(n)
+		} else {
+			n.state = TaskSucceeded
+			for _, d := range n.dependents {
+				d.waiting--
+				if d.waiting == 0 && d.state == TaskQueued {
+					ready = append(ready, d)
+				}
+			}
+		}
+		g.resolve()
+		g.mutex.Unlock()
+
+		g.add(addForce, ready)
+		return nil
+	}
+}
+
+// skip marks every node depending on n as skipped.
+// mutex must be held
+func (g *Graph) skip(n *graphNode) {
+	for _, d := range n.dependents {
+		if d.state == TaskQueued {
+			d.state = TaskSkipped
+			g.resolve()
+			g.skip(d)
+		}
+	}
+}
+
+// mutex must be held
+func (g *Graph) resolve() {
+	g.pending--
+	if g.pending == 0 {
+		close(g.done)
+	}
+}
+
+// cycle returns the path of a dependency cycle, or nil if there are none.
+// mutex must be held
+func (g *Graph) cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[*graphNode]int, len(g.order))
+	var path []*graphNode
+
+	var visit func(n *graphNode) []string
+	visit = func(n *graphNode) []string {
+		switch marks[n] {
+		case visited:
+			return nil
+		case visiting:
+			var cycle []string
+			for i := len(path) - 1; i >= 0; i-- {
+				if path[i] == n {
+					for _, p := range path[i:] {
+						cycle = append(cycle, p.name)
+					}
+					return append(cycle, n.name)
+				}
+			}
+		}
+
+		marks[n] = visiting
+		path = append(path, n)
+		for _, d := range n.dependents {
+			if cycle := visit(d); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		marks[n] = visited
+		return nil
+	}
+
+	for _, n := range g.order {
+		if cycle := visit(n); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package things
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGraph(t *testing.T) {
+	var mutex sync.Mutex
+	var ran []string
+	node := func(name string, err error) func() error {
+		return func() error {
+			mutex.Lock()
+			ran = append(ran, name)
+			mutex.Unlock()
+			return err
+		}
+	}
+
+	g := NewGraph()
+	g.Add("d", node("d", nil), "b", "c")
+	g.Add("c", node("c", errTask), "a")
+	g.Add("b", node("b", nil), "a")
+	g.Add("a", node("a", nil))
+	g.Add("e", node("e", nil), "b")
+	if err := g.Add("a", node("a", nil)); !ErrIs(err, ErrDuplicateNode) {
+		t.Errorf("Add() of duplicate node returned %v, wanted %v", err, ErrDuplicateNode)
+	}
+
+	q := NewQueue(nil)
+	if err := g.Start(q); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		go q.Run(0)
+	}
+
+	err := g.Wait(nil)
+	if !ErrIs(err, errTask) {
+		t.Errorf("Wait() = %v, wanted %v", err, errTask)
+	}
+	if name, _ := ErrGet(err, KeyNode); name != "c" {
+		t.Errorf("error has node %v, wanted %v", name, "c")
+	}
+	if len(ran) != 4 || ran[0] != "a" {
+		t.Errorf("wrong nodes executed; got %v", ran)
+	}
+
+	want := map[string]TaskState{
+		"a": TaskSucceeded,
+		"b": TaskSucceeded,
+		"c": TaskFailed,
+		"d": TaskSkipped,
+		"e": TaskSucceeded,
+	}
+	for name, state := range want {
+		if s, _ := g.State(name); s != state {
+			t.Errorf("node %v has state %v, wanted %v", name, s, state)
+		}
+	}
+	q.Cancel()
+}
+
+func TestGraph_Start(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes map[string][]string
+		err   error
+		key   ErrKey
+		value interface{}
+	}{
+		{
+			name:  "cycle",
+			nodes: map[string][]string{"a": {"c"}, "b": {"a"}, "c": {"b"}},
+			err:   ErrCycle,
+			key:   KeyCycle,
+		},
+		{
+			name:  "self dependency",
+			nodes: map[string][]string{"a": {"a"}},
+			err:   ErrCycle,
+			key:   KeyCycle,
+			value: []string{"a", "a"},
+		},
+		{
+			name:  "unknown dependency",
+			nodes: map[string][]string{"a": {"b"}},
+			err:   ErrUnknownDependency,
+			key:   KeyDependency,
+			value: "b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGraph()
+			for name, deps := range tt.nodes {
+				g.Add(name, func() error { return nil }, deps...)
+			}
+
+			q := NewQueue(nil)
+			err := g.Start(q)
+			if !ErrIs(err, tt.err) {
+				t.Fatalf("Start() = %v, wanted %v", err, tt.err)
+			}
+			value, ok := ErrGet(err, tt.key)
+			if !ok {
+				t.Errorf("error doesn't have %v set", tt.key)
+			}
+			if tt.value != nil && !reflect.DeepEqual(value, tt.value) {
+				t.Errorf("error has %v %v, wanted %v", tt.key, value, tt.value)
+			}
+			if q.Len() != 0 {
+				t.Errorf("tasks were added to the queue")
+			}
+		})
+	}
+}
+
+func TestGraph_Removed(t *testing.T) {
+	g := NewGraph()
+	g.Add("a", func() error { panic("node panicked") })
+	g.Add("b", func() error { return nil }, "a")
+	g.Add("c", func() error { return nil })
+	g.Add("d", func() error { return nil }, "c")
+
+	q := NewQueue(nil)
+	q.RecoverPanics(true)
+	if err := g.Start(q); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+
+	// A panicking node fails without halting the queue; c is still queued.
+	if _, err := q.RunQueued(1); err != nil {
+		t.Fatalf("RunQueued() = %v, wanted nil", err)
+	}
+	if s, _ := g.State("a"); s != TaskFailed {
+		t.Errorf("panicking node has state %v, wanted %v", s, TaskFailed)
+	}
+
+	// Removing c from the queue skips it and its dependents.
+	q.Reset(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := g.Wait(ctx)
+	if !ErrIs(err, ErrPanic) {
+		t.Errorf("Wait() = %v, wanted %v", err, ErrPanic)
+	}
+	for _, name := range []string{"b", "c", "d"} {
+		if s, _ := g.State(name); s != TaskSkipped {
+			t.Errorf("node %v has state %v, wanted %v", name, s, TaskSkipped)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package things
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Errors returned by Journal.
+var (
+	ErrUnknownTask    = errors.New("unknown task")
+	ErrJournalClosed  = errors.New("journal is closed")
+	ErrJournalOpen    = errors.New("journal is already open")
+	ErrJournalCorrupt = errors.New("corrupt journal record")
+)
+
+// KeyTask is set on errors relating to a named task.
+const KeyTask ErrKey = "task"
+
+// record types
+const (
+	journalAdd  byte = 1
+	journalDone byte = 2
+)
+
+const maxJournalRecord = 1 << 30
+
+// NewJournal creates a new Journal that adds tasks to q.
+func NewJournal(q *Queue) *Journal {
+	return &Journal{
+		queue: q,
+		funcs: make(map[string]func([]byte) error),
+	}
+}
+
+// Journal adds named tasks to a Queue, recording them in a file so that unfinished tasks survive a restart.
+//
+// Tasks are registered by name, and added with arguments that are stored in the file.
+// When a task succeeds or is skipped, it is marked as done in the file; a task that fails when the queue continues on error is not.
+// Opening the journal adds tasks that were not done to the queue in the order they were originally added,
+// so tasks are not lost if the process exits or crashes.
+// A task that succeeded as the process crashed, before it was marked as done, will be executed again.
+type Journal struct {
+	queue *Queue
+	mutex sync.Mutex
+	funcs map[string]func([]byte) error
+	file  *os.File
+	w     *bufio.Writer
+	id    uint64 // id of the last added task
+	err   error  // first error writing to the file
+}
+
+// journalEntry is a task recorded in the journal.
+type journalEntry struct {
+	id   uint64
+	name string
+	args []byte
+	done bool
+}
+
+// Register registers f as the task with the given name.
+// Tasks must be registered before they are added with Do or by Open.
+func (j *Journal) Register(name string, f func(args []byte) error) {
+	j.mutex.Lock()
+	j.funcs[name] = f
+	j.mutex.Unlock()
+}
+
+// Open opens the journal file at path, creating it if it doesn't exist,
+// and adds the unfinished tasks it contains to the queue.
+// The file is rewritten without finished tasks, and a partially written record at the end of the file is discarded.
+// If the file contains a task that isn't registered, ErrUnknownTask is returned with KeyTask set, and nothing is added to the queue.
+// If a record before the end of the file is corrupt, ErrJournalCorrupt is returned, and the file is left unchanged.
+func (j *Journal) Open(path string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file != nil {
+		return ErrJournalOpen
+	}
+
+	pending, last, err := readJournal(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, e := range pending {
+		if _, ok := j.funcs[e.name]; !ok {
+			err = ErrUnknownTask
+			ErrSet(&err, KeyTask, e.name)
+			return err
+		}
+	}
+
+	// Compact
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range pending {
+		writeJournalRecord(w, journalAdd, e)
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	j.file, j.w, j.id, j.err = f, w, last, nil
+	j.enqueue(pending)
+	return nil
+}
+
+// Do records a task in the journal and adds it to the queue.
+// It returns an error if the task isn't registered, or it couldn't be written to the file.
+// Journaled tasks are added regardless of the queue's capacity, as the journal already holds them.
+func (j *Journal) Do(name string, args []byte) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return ErrJournalClosed
+	}
+	if _, ok := j.funcs[name]; !ok {
+		err := ErrUnknownTask
+		ErrSet(&err, KeyTask, name)
+		return err
+	}
+
+	e := journalEntry{
+		id:   j.id + 1,
+		name: name,
+		args: args,
+	}
+	if err := j.write(journalAdd, e); err != nil {
+		return err
+	}
+	j.id++
+	j.enqueue([]journalEntry{e})
+	return nil
+}
+
+// Close closes the journal file, returning the first error encountered while marking tasks as done.
+// Tasks that finish after the journal is closed are not marked as done, and will be added to the queue again by Open.
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.file == nil {
+		return ErrJournalClosed
+	}
+	err := j.w.Flush()
+	if cerr := j.file.Close(); err == nil {
+		err = cerr
+	}
+	if j.err != nil {
+		err = j.err
+	}
+	j.file, j.w = nil, nil
+	return err
+}
+
+// adds entries to the queue.
+// mutex must be held
+func (j *Journal) enqueue(entries []journalEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	j.queue.insert(nil, addForce, TaskOptions{}, len(entries), func(buff []task) {
+		for i := range buff {
+			f, args, id := j.funcs[entries[i].name], entries[i].args, entries[i].id
+			buff[i].f = func() error {
+				return f(args)
+			}
+			buff[i].extend().onDone = func(s TaskState) {
+				// A task that failed when the queue continues on error is kept, to be retried by Open.
+				if s == TaskSucceeded || s == TaskSkipped {
+					j.done(id)
+				}
+			}
+		}
+	})
+}
+
+// marks a task as done.
+func (j *Journal) done(id uint64) {
+	j.mutex.Lock()
+	if j.file != nil {
+		if err := j.write(journalDone, journalEntry{id: id}); err != nil && j.err == nil {
+			j.err = err
+		}
+	}
+	j.mutex.Unlock()
+}
+
+// writes a record and syncs the file.
+// mutex must be held
+func (j *Journal) write(kind byte, e journalEntry) error {
+	writeJournalRecord(j.w, kind, e)
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// writeJournalRecord writes a record to w.
+// A record is the uvarint length of the payload, the payload, and the payload's CRC-32.
+func writeJournalRecord(w *bufio.Writer, kind byte, e journalEntry) {
+	var buf [binary.MaxVarintLen64]byte
+	payload := []byte{kind}
+	payload = append(payload, buf[:binary.PutUvarint(buf[:], e.id)]...)
+	if kind == journalAdd {
+		payload = append(payload, buf[:binary.PutUvarint(buf[:], uint64(len(e.name)))]...)
+		payload = append(payload, e.name...)
+		payload = append(payload, buf[:binary.PutUvarint(buf[:], uint64(len(e.args)))]...)
+		payload = append(payload, e.args...)
+	}
+
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(payload)))])
+	w.Write(payload)
+	binary.BigEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(payload))
+	w.Write(buf[:4])
+}
+
+// readJournalRecord reads a record from r, given the highest task id in the records before it.
+// It returns io.EOF only if there are no more records, io.ErrUnexpectedEOF if the record is the last in the file
+// and was only partially written, and ErrJournalCorrupt if it is corrupt.
+func readJournalRecord(r *bufio.Reader, last uint64) (kind byte, e journalEntry, err error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, e, err
+	}
+	if err != nil || n > maxJournalRecord {
+		return 0, e, ErrJournalCorrupt
+	}
+	if n == 0 {
+		if torn(r) {
+			return 0, e, io.ErrUnexpectedEOF
+		}
+		return 0, e, ErrJournalCorrupt
+	}
+
+	payload := make([]byte, n+4)
+	if m, _ := io.ReadFull(r, payload); m < len(payload) {
+		if partial(payload[:m], n, last) {
+			return 0, e, io.ErrUnexpectedEOF
+		}
+		return 0, e, ErrJournalCorrupt
+	}
+	payload, sum := payload[:n], payload[n:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(sum) {
+		if torn(r) {
+			return 0, e, io.ErrUnexpectedEOF
+		}
+		return 0, e, ErrJournalCorrupt
+	}
+
+	p := bytes.NewReader(payload[1:])
+	kind = payload[0]
+	if e.id, err = binary.ReadUvarint(p); err != nil {
+		return 0, e, ErrJournalCorrupt
+	}
+	switch kind {
+	case journalDone:
+	case journalAdd:
+		var name []byte
+		if name, err = readJournalBytes(p); err != nil {
+			return 0, e, err
+		}
+		if e.args, err = readJournalBytes(p); err != nil {
+			return 0, e, err
+		}
+		e.name = string(name)
+	default:
+		return 0, e, ErrJournalCorrupt
+	}
+	return kind, e, nil
+}
+
+// partial reports whether p, the bytes of a record of length n cut short by the end of the file,
+// is the start of a plausible record following a record with the highest task id last.
+// A corrupt length prefix near the end of a file also cuts the record short, but the payload it covers doesn't fit it.
+func partial(p []byte, n, last uint64) bool {
+	if uint64(len(p)) > n {
+		p = p[:n]
+	}
+	r := bytes.NewReader(p)
+	kind, err := r.ReadByte()
+	if err != nil {
+		return true
+	}
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err == io.EOF || err == io.ErrUnexpectedEOF
+	}
+	read := func() uint64 {
+		return uint64(len(p) - r.Len())
+	}
+
+	switch kind {
+	case journalDone:
+		return id <= last && read() == n
+	case journalAdd:
+		// Tasks are added with increasing ids.
+		if id <= last {
+			return false
+		}
+		var size uint64
+		for i := 0; i < 2; i++ { // name, then args
+			l, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err == io.EOF || err == io.ErrUnexpectedEOF
+			}
+			size = read() + l
+			if size > n {
+				return false
+			}
+			if l > uint64(r.Len()) {
+				return true
+			}
+			r.Seek(int64(l), io.SeekCurrent)
+		}
+		return size == n
+	}
+	return false
+}
+
+func readJournalBytes(p *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(p)
+	if err != nil || n > uint64(p.Len()) {
+		return nil, ErrJournalCorrupt
+	}
+	b := make([]byte, n)
+	p.Read(b)
+	return b, nil
+}
+
+// readJournal returns the unfinished tasks in the journal at path in the order they were added,
+// and the highest task id in the journal.
+// A partially written record at the end of the file is ignored, but any other corrupt record returns ErrJournalCorrupt.
+func readJournal(path string) (pending []journalEntry, last uint64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var entries []journalEntry
+	index := make(map[uint64]int)
+	r := bufio.NewReader(f)
+	for {
+		kind, e, err := readJournalRecord(r, last)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if e.id > last {
+			last = e.id
+		}
+		switch kind {
+		case journalAdd:
+			index[e.id] = len(entries)
+			entries = append(entries, e)
+		case journalDone:
+			if i, ok := index[e.id]; ok {
+				entries[i].done = true
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if !e.done {
+			pending = append(pending, e)
+		}
+	}
+	return pending, last, nil
+}
+
+// torn reports whether the rest of a journal after a record that fails its checks is empty or zeroed,
+// as it is when a crash interrupts writing the last record.
+func torn(r *bufio.Reader) bool {
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return false
+	}
+	for _, b := range rest {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+package things
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	var ran []string
+	record := func(args []byte) error {
+		ran = append(ran, string(args))
+		return nil
+	}
+	fail := func(args []byte) error {
+		if string(args) == "b" {
+			return errTask
+		}
+		return record(args)
+	}
+
+	q := NewQueue(nil)
+	j := NewJournal(q)
+	j.Register("task", fail)
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	for _, args := range []string{"a", "b", "c"} {
+		if err := j.Do("task", []byte(args)); err != nil {
+			t.Fatalf("Do() = %v", err)
+		}
+	}
+	if err := j.Do("unknown", nil); !ErrIs(err, ErrUnknownTask) {
+		t.Errorf("Do() with unregistered task returned %v, wanted %v", err, ErrUnknownTask)
+	}
+
+	if _, err := q.RunQueued(0); err != errTask {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	// Simulate a crash partway through writing a record.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{10, journalAdd, 4})
+	f.Close()
+
+	q = NewQueue(nil)
+	j = NewJournal(q)
+	if err := j.Open(path); !ErrIs(err, ErrUnknownTask) {
+		t.Errorf("Open() with unregistered task returned %v, wanted %v", err, ErrUnknownTask)
+	}
+	j.Register("task", record)
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	if err := j.Do("task", []byte("d")); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	j.Close()
+
+	want := []string{"a", "b", "c", "d"}
+	if len(ran) != len(want) {
+		t.Fatalf("executed %v, wanted %v", ran, want)
+	}
+	for i := range want {
+		if ran[i] != want[i] {
+			t.Fatalf("executed %v, wanted %v", ran, want)
+		}
+	}
+
+	q = NewQueue(nil)
+	j = NewJournal(q)
+	j.Register("task", record)
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if q.Len() != 0 {
+		t.Errorf("finished tasks were added to the queue again")
+	}
+	j.Close()
+}
+
+func TestJournal_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	nop := func([]byte) error { return nil }
+
+	j := NewJournal(NewQueue(nil))
+	j.Register("task", nop)
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	for _, args := range []string{"a", "b", "c"} {
+		if err := j.Do("task", []byte(args)); err != nil {
+			t.Fatalf("Do() = %v", err)
+		}
+	}
+	j.Close()
+
+	// Corrupt a byte of the first record.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[bytes.IndexByte(data, 'a')] = 'x'
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueue(nil)
+	j = NewJournal(q)
+	j.Register("task", nop)
+	if err := j.Open(path); err != ErrJournalCorrupt {
+		t.Errorf("Open() with a corrupt record returned %v, wanted %v", err, ErrJournalCorrupt)
+	}
+	if q.Len() != 0 {
+		t.Errorf("tasks were added from a corrupt journal")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(data) {
+		t.Errorf("corrupt journal was rewritten")
+	}
+}
+
+func TestJournal_CorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	nop := func([]byte) error { return nil }
+
+	j := NewJournal(NewQueue(nil))
+	j.Register("task", nop)
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	for _, args := range []string{"a", "b", "c"} {
+		if err := j.Do("task", []byte(args)); err != nil {
+			t.Fatalf("Do() = %v", err)
+		}
+	}
+	j.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A length prefix running past the end of the file isn't a torn record if the payload doesn't fit it.
+	corrupt := append([]byte{0x7f}, data[1:]...)
+	if err := os.WriteFile(path, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	q := NewQueue(nil)
+	j = NewJournal(q)
+	j.Register("task", nop)
+	if err := j.Open(path); err != ErrJournalCorrupt {
+		t.Errorf("Open() with a corrupt length returned %v, wanted %v", err, ErrJournalCorrupt)
+	}
+	if q.Len() != 0 {
+		t.Errorf("tasks were added from a corrupt journal")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(corrupt) {
+		t.Errorf("corrupt journal was rewritten")
+	}
+
+	// Cutting the last record short is.
+	if err := os.WriteFile(path, data[:len(data)-3], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() with a torn record = %v", err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("have %v tasks after opening a torn journal, wanted 2", q.Len())
+	}
+	j.Close()
+}
+
+func TestJournal_ContinueOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	q := NewQueue(nil)
+	q.ContinueOnError(true)
+	j := NewJournal(q)
+	j.Register("task", func([]byte) error { return errTask })
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if err := j.Do("task", nil); err != nil {
+		t.Fatalf("Do() = %v", err)
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("RunQueued() = %v, wanted nil", err)
+	}
+	j.Close()
+
+	q = NewQueue(nil)
+	j = NewJournal(q)
+	j.Register("task", func([]byte) error { return nil })
+	if err := j.Open(path); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if q.Len() != 1 {
+		t.Errorf("have %v tasks after reopening, wanted the failed task", q.Len())
+	}
+	j.Close()
+}
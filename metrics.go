@@ -0,0 +1,208 @@
+package things
+
+import (
+	"encoding/json"
+	"expvar"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics returns the Queue's metrics, starting to record them if this is the first call.
+// Tasks taken by runners before the first call are not recorded.
+func (q *Queue) Metrics() *QueueMetrics {
+	q.mutex.Lock()
+	if q.metrics == nil {
+		q.metrics = &QueueMetrics{queue: q}
+		q.direct()
+	}
+	m := q.metrics
+	q.mutex.Unlock()
+	return m
+}
+
+// QueueMetrics records counters and latencies for a Queue.
+// It implements expvar.Var, formatting the metrics as a JSON object.
+type QueueMetrics struct {
+	enqueued  uint64
+	started   uint64
+	succeeded uint64
+	failed    uint64
+	halts     uint64
+	resumes   uint64
+
+	// WaitTime is the time tasks spend queued before they are started.
+	WaitTime Histogram
+
+	// ExecTime is the time tasks take to execute.
+	ExecTime Histogram
+
+	throughput rate
+	queue      *Queue
+}
+
+// QueueCounts are the counters recorded by QueueMetrics.
+type QueueCounts struct {
+	Enqueued  uint64  `json:"enqueued"`   // tasks added
+	Started   uint64  `json:"started"`    // task executions, including retries
+	Succeeded uint64  `json:"succeeded"`  // tasks that succeeded
+	Failed    uint64  `json:"failed"`     // task executions that returned an error
+	Halts     uint64  `json:"halts"`      // times execution halted
+	Resumes   uint64  `json:"resumes"`    // times execution resumed
+	Pending   int     `json:"pending"`    // tasks currently queued
+	Running   int     `json:"running"`    // active runners
+	PerSecond float64 `json:"per_second"` // tasks succeeded per second over the last minute
+}
+
+// Counts returns the current counters.
+func (m *QueueMetrics) Counts() QueueCounts {
+	c := QueueCounts{
+		Enqueued:  atomic.LoadUint64(&m.enqueued),
+		Started:   atomic.LoadUint64(&m.started),
+		Succeeded: atomic.LoadUint64(&m.succeeded),
+		Failed:    atomic.LoadUint64(&m.failed),
+		Halts:     atomic.LoadUint64(&m.halts),
+		Resumes:   atomic.LoadUint64(&m.resumes),
+		PerSecond: m.throughput.perSecond(),
+	}
+	m.queue.mutex.Lock()
+	c.Pending = m.queue.len()
+	c.Running = m.queue.running
+	m.queue.mutex.Unlock()
+	return c
+}
+
+// Publish publishes the metrics with expvar under name.
+// Like expvar.Publish, it panics if name is already published.
+func (m *QueueMetrics) Publish(name string) {
+	expvar.Publish(name, m)
+}
+
+// String implements expvar.Var
+func (m *QueueMetrics) String() string {
+	b, _ := json.Marshal(struct {
+		QueueCounts
+		WaitTime *Histogram `json:"wait_time"`
+		ExecTime *Histogram `json:"exec_time"`
+	}{
+		QueueCounts: m.Counts(),
+		WaitTime:    &m.WaitTime,
+		ExecTime:    &m.ExecTime,
+	})
+	return string(b)
+}
+
+// histogramBuckets is the number of buckets in a Histogram.
+// Bucket i counts durations less than 2^i microseconds, with the last also counting longer durations.
+const histogramBuckets = 32
+
+// Histogram is a histogram of durations with exponentially sized buckets.
+// It is safe for concurrent use, and implements expvar.Var.
+type Histogram struct {
+	count   uint64
+	sum     uint64 // nanoseconds
+	buckets [histogramBuckets]uint64
+}
+
+// Observe records a duration.
+func (h *Histogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := bits.Len64(uint64(d / time.Microsecond))
+	if i >= histogramBuckets {
+		i = histogramBuckets - 1
+	}
+	atomic.AddUint64(&h.buckets[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// Count returns the number of recorded durations.
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Mean returns the mean of the recorded durations.
+func (h *Histogram) Mean() time.Duration {
+	c := atomic.LoadUint64(&h.count)
+	if c == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadUint64(&h.sum) / c)
+}
+
+// Quantile returns an upper bound for the q quantile of recorded durations,
+// being the upper bound of the bucket it falls in.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	var counts [histogramBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.buckets[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(total))
+	var n uint64
+	for i := range counts {
+		n += counts[i]
+		if n > rank {
+			return time.Duration(1<<uint(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(1<<(histogramBuckets-1)) * time.Microsecond
+}
+
+// MarshalJSON implements json.Marshaler, formatting durations in nanoseconds.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"count": h.Count(),
+		"mean":  h.Mean(),
+		"p50":   h.Quantile(0.5),
+		"p90":   h.Quantile(0.9),
+		"p99":   h.Quantile(0.99),
+	})
+}
+
+// String implements expvar.Var
+func (h *Histogram) String() string {
+	b, _ := h.MarshalJSON()
+	return string(b)
+}
+
+// rate counts events over the last minute.
+type rate struct {
+	mutex   sync.Mutex
+	counts  [60]uint64
+	seconds [60]int64 // the second each count is for
+}
+
+func (r *rate) add() {
+	now := time.Now().Unix()
+	i := now % 60
+	r.mutex.Lock()
+	if r.seconds[i] != now {
+		r.seconds[i] = now
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+	r.mutex.Unlock()
+}
+
+// perSecond returns the mean number of events per second over the last minute.
+func (r *rate) perSecond() float64 {
+	now := time.Now().Unix()
+	var n uint64
+	r.mutex.Lock()
+	for i := range r.counts {
+		if now-r.seconds[i] < 60 {
+			n += r.counts[i]
+		}
+	}
+	r.mutex.Unlock()
+	return float64(n) / 60
+}
@@ -0,0 +1,56 @@
+package things
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for i := 0; i < 90; i++ {
+		h.Observe(3 * time.Microsecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.Observe(time.Second)
+	}
+
+	if c := h.Count(); c != 100 {
+		t.Errorf("Count() = %v, want %v", c, 100)
+	}
+	if m, want := h.Mean(), (90*3*time.Microsecond+10*time.Second)/100; m != want {
+		t.Errorf("Mean() = %v, want %v", m, want)
+	}
+	if q := h.Quantile(0.5); q != 4*time.Microsecond {
+		t.Errorf("Quantile(0.5) = %v, want %v", q, 4*time.Microsecond)
+	}
+	if q := h.Quantile(0.95); q < time.Second || q > 2*time.Second {
+		t.Errorf("Quantile(0.95) = %v, want between %v and %v", q, time.Second, 2*time.Second)
+	}
+}
+
+func TestQueueMetrics(t *testing.T) {
+	q := NewQueue(nil)
+	m := q.Metrics()
+	q.Do(testFuncs(10)...)
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	num = 0
+
+	c := m.Counts()
+	if c.Enqueued != 10 || c.Started != 10 || c.Succeeded != 10 || c.Pending != 0 {
+		t.Errorf("wrong counts %+v", c)
+	}
+	if m.ExecTime.Count() != 10 || m.WaitTime.Count() != 10 {
+		t.Errorf("latencies not recorded")
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(m.String()), &v); err != nil {
+		t.Fatalf("String() isn't valid JSON: %v", err)
+	}
+	if v["succeeded"] != 10.0 {
+		t.Errorf("JSON has %v succeeded, wanted %v", v["succeeded"], 10)
+	}
+}
@@ -0,0 +1,186 @@
+package things
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Errors returned by Pipeline.
+var (
+	ErrPipelineEmpty   = errors.New("pipeline has no stages")
+	ErrPipelineStarted = errors.New("pipeline already started")
+	ErrPipelineClosed  = errors.New("pipeline closed")
+	ErrStageCapacity   = errors.New("stage capacity must be at least 1")
+)
+
+// KeyStage is the name of the pipeline stage that returned the error.
+const KeyStage ErrKey = "stage"
+
+// NewPipeline creates a new Pipeline with the given context.
+// A nil context is valid.
+func NewPipeline(ctx context.Context) *Pipeline {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	p := &Pipeline{}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	return p
+}
+
+// Pipeline is a chain of stages, each of which processes items put into it and emits items to the next stage.
+// Each stage is backed by its own Queue, executing items concurrently on its own runners,
+// so items may be reordered by stages with more than one runner.
+//
+// Stages have a limited capacity. Once a stage is full, the previous stage blocks when emitting items until it has space,
+// and Put blocks once the first stage is full, so a slow stage slows the whole pipeline.
+//
+// An error returned by a stage halts the whole pipeline; Put and Close return it with KeyStage set.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex   sync.Mutex
+	stages  []*pipelineStage
+	started bool
+	closed  bool
+	err     error
+}
+
+type pipelineStage struct {
+	name  string
+	f     func(ctx context.Context, v interface{}, emit func(interface{}) error) error
+	queue *Queue
+}
+
+// Stage adds a stage to the end of the pipeline, executed by the given number of runners and holding at most capacity items.
+//
+// f is called for each item put into the stage, and calls emit for each item it passes to the next stage;
+// it can emit any number of items. emit blocks while the next stage is full, and returns an error if the pipeline halts,
+// which f should return. Items emitted by the last stage are discarded.
+// ctx is cancelled when the pipeline halts.
+//
+// Stages cannot be added after the first item has been put into the pipeline.
+// ErrStageCapacity is returned with KeyStage set if capacity is less than 1.
+func (p *Pipeline) Stage(name string, runners, capacity int, f func(ctx context.Context, v interface{}, emit func(interface{}) error) error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.started || p.closed {
+		return ErrPipelineStarted
+	}
+	if capacity < 1 {
+		err := ErrStageCapacity
+		ErrSet(&err, KeyStage, name)
+		return err
+	}
+
+	q := NewQueue(p.ctx)
+	q.Capacity(capacity)
+	q.Start(runners, runners)
+	p.stages = append(p.stages, &pipelineStage{
+		name:  name,
+		f:     f,
+		queue: q,
+	})
+	return nil
+}
+
+// Put adds an item to the first stage of the pipeline, waiting while it is full or until ctx finishes.
+// It returns the error that halted the pipeline if it has halted.
+// A nil context is valid.
+func (p *Pipeline) Put(ctx context.Context, v interface{}) error {
+	p.mutex.Lock()
+	switch {
+	case p.closed:
+		p.mutex.Unlock()
+		return ErrPipelineClosed
+	case p.err != nil:
+		err := p.err
+		p.mutex.Unlock()
+		return err
+	case len(p.stages) == 0:
+		p.mutex.Unlock()
+		return ErrPipelineEmpty
+	}
+	p.started = true
+	p.mutex.Unlock()
+
+	// Stop waiting if the pipeline halts.
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := p.put(ctx, 0, v)
+	p.mutex.Lock()
+	if err != nil && p.err != nil {
+		err = p.err
+	}
+	p.mutex.Unlock()
+	return err
+}
+
+// Close waits for the items in the pipeline to pass through every stage, and stops the stages' runners.
+// It returns the error that halted the pipeline, or the pipeline's context's error.
+// Items cannot be put into the pipeline after it is closed.
+func (p *Pipeline) Close() error {
+	p.mutex.Lock()
+	p.closed = true
+	stages := p.stages
+	p.mutex.Unlock()
+
+	for _, s := range stages {
+		// Once a stage is caught up, the previous stages have emitted everything they will.
+		s.queue.Wait()
+	}
+	for _, s := range stages {
+		s.queue.Stop(nil)
+	}
+
+	p.mutex.Lock()
+	err := p.err
+	p.mutex.Unlock()
+	if err == nil {
+		err = p.ctx.Err()
+	}
+	p.cancel()
+	return err
+}
+
+// adds an item to the i'th stage.
+func (p *Pipeline) put(ctx context.Context, i int, v interface{}) error {
+	if i == len(p.stages) {
+		return nil
+	}
+	s := p.stages[i]
+	return s.queue.insert(ctx, addWait, TaskOptions{}, 1, func(buff []task) {
+		buff[0].extend().fctx = func(ctx context.Context) error {
+			err := s.f(ctx, v, func(out interface{}) error {
+				return p.put(ctx, i+1, out)
+			})
+			if err != nil && ctx.Err() == nil {
+				ErrSet(&err, KeyStage, s.name)
+				p.fail(err)
+			}
+			return err
+		}
+	})
+}
+
+// halts the pipeline with err.
+func (p *Pipeline) fail(err error) {
+	p.mutex.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mutex.Unlock()
+	p.cancel()
+}
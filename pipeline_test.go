@@ -0,0 +1,75 @@
+package things
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	p := NewPipeline(nil)
+	var sum int64
+	p.Stage("double", 4, 8, func(ctx context.Context, v interface{}, emit func(interface{}) error) error {
+		return emit(v.(int) * 2)
+	})
+	p.Stage("split", 2, 8, func(ctx context.Context, v interface{}, emit func(interface{}) error) error {
+		if err := emit(v); err != nil {
+			return err
+		}
+		return emit(v)
+	})
+	p.Stage("sum", 1, 1, func(ctx context.Context, v interface{}, emit func(interface{}) error) error {
+		atomic.AddInt64(&sum, int64(v.(int)))
+		return nil
+	})
+	if err := p.Stage("unbounded", 1, 0, nil); !ErrIs(err, ErrStageCapacity) {
+		t.Errorf("Stage() with capacity 0 = %v, wanted %v", err, ErrStageCapacity)
+	}
+
+	for i := 1; i <= 100; i++ {
+		if err := p.Put(nil, i); err != nil {
+			t.Fatalf("Put() = %v", err)
+		}
+	}
+	if err := p.Stage("late", 1, 1, nil); err != ErrPipelineStarted {
+		t.Errorf("Stage() after Put = %v, wanted %v", err, ErrPipelineStarted)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if want := int64(2 * 2 * 5050); sum != want {
+		t.Errorf("pipeline summed %v, wanted %v", sum, want)
+	}
+	if err := p.Put(nil, 1); err != ErrPipelineClosed {
+		t.Errorf("Put() after Close = %v, wanted %v", err, ErrPipelineClosed)
+	}
+}
+
+func TestPipeline_Error(t *testing.T) {
+	errStage := errors.New("stage error")
+	p := NewPipeline(nil)
+	p.Stage("a", 2, 2, func(ctx context.Context, v interface{}, emit func(interface{}) error) error {
+		return emit(v)
+	})
+	p.Stage("b", 2, 2, func(ctx context.Context, v interface{}, emit func(interface{}) error) error {
+		if v.(int) == 5 {
+			return errStage
+		}
+		return nil
+	})
+
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = p.Put(nil, i)
+	}
+	if cerr := p.Close(); err == nil {
+		err = cerr
+	}
+	if !ErrIs(err, errStage) {
+		t.Fatalf("pipeline returned %v, wanted %v", err, errStage)
+	}
+	if v, _ := ErrGet(err, KeyStage); v != "b" {
+		t.Errorf("error has stage %v, wanted %v", v, "b")
+	}
+}
@@ -2,10 +2,18 @@ package things
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const buffSize = 2048 // buffer size for Queue
+const buffSize = 2048 // default maximum batch size
+
+const segmentSize = 256 // number of tasks in a lane segment, unless more are added at once
+
+// ErrKey is the type of keys for values the Queue sets with ErrSet.
+type ErrKey string
 
 // NewQueue creates a new queue with the given context.
 // Calling new(Queue) or Queue{} is sufficent.
@@ -29,20 +37,107 @@ type Queue struct {
 	originalCtx context.Context // Original context.
 	ctx         context.Context // Our internal context
 	ctxCancel   context.CancelFunc
+	retry       *RetryPolicy
+	limit       *limiter
+	hooks       *Hooks
+	metrics     *QueueMetrics
+	catch       bool // recover panicking tasks
+	cont        bool // continue on error
+	failures    Errors
+	timeout     time.Duration // default timeout of context-aware tasks
+	maxBatch    int           // maximum tasks taken by a runner at once, or 0 for buffSize
+	taskTime    time.Duration // average execution time of tasks
+	sched       *scheduler
 	exitError   error
 	running     int
+	halts       int       // number of times execution has halted
+	haltError   error     // error of the last halt, as returned by Err
+	pool        *pool     // runners managed by Start
+	runners     []*runner // calls to Run and RunQueued, and pool workers
+	history     []Halt    // recent halts
 
 	// Queue
-	queue []func() error
-	off   int // queue index for reading
-	count int // counter for runners
+	inbox    atomic.Value // *inbox of tasks added by Do without the mutex
+	spare    *inbox       // drained inbox, installed by the next drain
+	locked   int32        // 1 if Do must take the mutex, see direct; accessed atomically
+	lanes    []*lane      // local lanes then remote ones, each sorted by descending priority then class
+	seq      uint64       // sequence number of the last added task
+	keys     map[interface{}]*keyLane
+	waiting  int             // tasks waiting in keys
+	labels   map[string]int  // number of pending tasks with each label
+	capacity int             // maximum queued tasks, or 0 if unbounded
+	blocked  int             // producers waiting for space
+	weights  map[string]int  // class weights
+	turns    map[turn]string // class whose turn it is in each priority
+
+	// Tasks added with DoUnique.
+	unique       map[interface{}]*uniqueTask // by key
+	uniqueDone   []*uniqueTask               // succeeded tasks whose keys are remembered, oldest first
+	uniqueWindow time.Duration               // how long keys are remembered after their task succeeds
 
 	// Task after-error recovery.
-	recover     [][]func() error
-	errCountInd int // recover index of errored runner
-	errIndex    int // queue index of errored task
+	recover []task    // unexecuted tasks returned by runners
+	errSeq  uint64    // sequence number of errored task
+	failed  []failure // tasks that failed since execution was resumed
+}
+
+// task is a single queued function.
+// Tasks are copied whenever they move, so options shared by tasks added together are kept in their group.
+type task struct {
+	f     func() error
+	seq   uint64 // position in the queue; also the task's ID, unless it is keyed
+	group *taskGroup
+	ext   *taskExt // nil unless the task uses one of its fields
 }
 
+// taskExt holds the fields few tasks use, keeping tasks small to copy.
+type taskExt struct {
+	fctx     func(context.Context) error // used instead of f if not nil
+	id       uint64                      // see TaskID; set for keyed tasks, whose seq changes when they are queued
+	attempts int                         // failed attempts
+	handle   *Handle
+	onDone   func(TaskState) // called once the task is done, see Queue.done
+}
+
+// returns the task's extension, adding one if it has none.
+func (t *task) extend() *taskExt {
+	if t.ext == nil {
+		t.ext = &taskExt{}
+	}
+	return t.ext
+}
+
+// returns the task's ID, see TaskID.
+func (t *task) id() uint64 {
+	if t.ext != nil && t.ext.id != 0 {
+		return t.ext.id
+	}
+	return t.seq
+}
+
+// returns the task's context-aware function, or nil if it has none.
+func (t *task) fctx() func(context.Context) error {
+	if t.ext == nil {
+		return nil
+	}
+	return t.ext.fctx
+}
+
+// taskGroup holds the options of tasks added together. It is shared, so it mustn't be modified.
+type taskGroup struct {
+	priority int
+	retry    *RetryPolicy
+	key      interface{}
+	label    string
+	class    string
+	timeout  time.Duration
+	remote   *remoteTask // executed by remote workers if not nil
+	enqueued time.Time   // zero unless the Queue has hooks or metrics
+}
+
+// group of tasks added without options while the Queue has no hooks or metrics.
+var plainGroup = &taskGroup{}
+
 // Run executes tasks in the Queue.
 // It blocks until n tasks are complete.
 // If n is 0 or negative, it will only return on error.
@@ -57,32 +152,33 @@ type Queue struct {
 func (q *Queue) Run(n int) error {
 	q.mutex.Lock()
 	q.init()
-	var buff []func() error
-	if n <= 0 {
-		buff = make([]func() error, buffSize)
-	} else {
-		buff = make([]func() error, n)
-	}
+	r := q.register()
 
 	if q.err() != nil {
 		q.resume()
 	}
 
 	for {
-		for q.len() == 0 && q.ctx.Err() == nil {
+		halts := q.halts
+		for q.ready(false) == 0 && q.ctx.Err() == nil {
 			q.cond.Wait()
 		}
-		if n > 0 && n < len(buff) {
-			buff = buff[:n]
+		if q.halts != halts {
+			// Execution halted while we waited, and was resumed before we woke.
+			q.unregister(r)
+			q.mutex.Unlock()
+			return context.Canceled
 		}
-		e, err := q.run(buff)
+		e, err := q.run(r, n)
 		if err != nil {
+			q.unregister(r)
 			q.mutex.Unlock()
 			return err
 		}
 		if n > 0 {
 			n -= e
 			if n <= 0 {
+				q.unregister(r)
 				q.mutex.Unlock()
 				return nil
 			}
@@ -95,110 +191,196 @@ func (q *Queue) Run(n int) error {
 // If n is 0 or negative, RunQueued returns only when the Queue is empty.
 func (q *Queue) RunQueued(n int) (int, error) {
 	q.mutex.Lock()
+	r := q.register()
 
 	if q.err() != nil {
 		q.resume()
 	}
 
-	var buff []func() error
-	if n <= 0 {
-		if q.len() > buffSize {
-			buff = make([]func() error, buffSize)
-		} else {
-			buff = make([]func() error, q.len())
-		}
-	} else {
-		if n > buffSize {
-			buff = make([]func() error, buffSize)
-		} else {
-			buff = make([]func() error, n)
-		}
-	}
-
 	var done int
 
-	for q.len() > 0 {
-		if n > 0 && n-done < len(buff) {
-			buff = buff[:n-done]
-		}
-		e, err := q.run(buff)
+	for q.ready(false) > 0 {
+		e, err := q.run(r, n-done)
 		done += e
 		if err != nil {
+			q.unregister(r)
 			q.mutex.Unlock()
 			return done, err
 		}
 		if n > 0 && done >= n {
+			q.unregister(r)
 			q.mutex.Unlock()
 			return done, nil
 		}
 	}
 
+	q.unregister(r)
 	q.mutex.Unlock()
 	return done, nil
 }
 
+// executes a batch of tasks, of at most n tasks if n is positive.
 // mutex must be held
-func (q *Queue) run(buff []func() error) (int, error) {
+func (q *Queue) run(r *runner, n int) (int, error) {
 	if err := q.ctx.Err(); err != nil {
 		return 0, err
 	}
 
+	var c int
+	var start time.Time
 	q.running++
 	defer func() {
+		if c > 0 {
+			q.observe(r, time.Since(start))
+		}
+		r.batch = nil
 		q.cond.Broadcast()
 	}()
 
-	ctx := q.ctx
-	c, index := q.getTasks(buff)
+	ctx, remote := q.ctx, r.remote
+	retry, limit, catch, cont, timeout := q.retry, q.limit, q.catch, q.cont, q.timeout
+	obs := observer{q.hooks, q.metrics}
+	plain := remote == nil && limit == nil && !catch && !obs.active() // tasks without options need only be called
+	buff := r.buffer(q.batchSize(n))
+	c = q.getTasks(buff, remote != nil)
+	q.freed()
+	if c > 0 {
+		r.batch = buff[:c]
+		atomic.StoreInt32(&r.current, 0)
+	}
 
 	q.mutex.Unlock()
+	start = time.Now()
+
+	if remote != nil && c > 0 {
+		if err := remote.lease(buff[:c], cont); err != nil {
+			q.mutex.Lock()
+			q.abandon(buff[:c])
+			return 0, err
+		}
+	}
 
 	var err error
 	for i := 0; i < c; i++ {
-		if ctx.Err() != nil {
+		atomic.StoreInt32(&r.current, int32(i))
+		// Remote workers finish their batch, as they can't be interrupted.
+		if ctx.Err() != nil && remote == nil {
 			// Check if the context has just switched.
 			q.mutex.Lock()
 			ctx = q.ctx
 			err = ctx.Err()
 			if err != nil {
 				q.cancel()
-				q.returnTasks(buff[i:c], index)
-				q.running--
-				if q.running == 0 {
-					// We're last
-					q.parseRecovered()
-				}
+				buff[i].setState(TaskQueued, nil)
+				q.returnTasks(buff[i:c])
+				q.exit()
 				return i, err
 			}
 			q.mutex.Unlock()
 		}
-		err = buff[i]()
+		if limit != nil && remote == nil && limit.wait(ctx) != nil {
+			i--
+			continue
+		}
+		if plain && buff[i].ext == nil {
+			if err = buff[i].f(); err == nil && buff[i].group.key == nil {
+				continue
+			}
+		} else {
+			buff[i].setState(TaskRunning, nil)
+			start := obs.start(&buff[i])
+			if remote != nil {
+				err = remote.result()
+				if err == errDisconnected {
+					q.mutex.Lock()
+					buff[i].setState(TaskQueued, nil)
+					q.abandon(buff[i:c])
+					return i, err
+				}
+			} else {
+				err = buff[i].exec(ctx, catch, timeout)
+			}
+			if err != nil && buff[i].fctx() != nil && ctx.Err() != nil {
+				// Interrupted; return it to the queue.
+				i--
+				continue
+			}
+			obs.done(&buff[i], err, start)
+		}
+		if err != nil && remote == nil && buff[i].wait(ctx, retry, err) {
+			i--
+			continue
+		}
 		if err != nil {
+			buff[i].fail(&err)
+			if cont {
+				q.record(&buff[i], &err)
+				q.done(&buff[i], TaskFailed, err)
+				err = nil
+				continue
+			}
+			buff[i].setState(TaskFailed, err)
 			q.mutex.Lock()
 			if q.exitError == nil {
 				q.exitError = err
+				q.errSeq = buff[i].seq
 			}
+			q.failed = append(q.failed, failure{buff[i].seq, TaskID(buff[i].id()), buff[i].position(err)})
 			q.cancel()
-			q.failTask(buff[i:c], index)
-			q.running--
-			if q.running == 0 {
-				q.parseRecovered()
-			}
+			q.returnTasks(buff[i:c])
+			q.exit()
 			return i, err
 		}
+		q.done(&buff[i], TaskSucceeded, nil)
 	}
 
 	q.mutex.Lock()
-	q.running--
+	if q.err() != nil {
+		// Execution stopped while we finished our tasks, and we might be the last to leave.
+		q.exit()
+	} else {
+		q.running--
+	}
 	return c, err
 }
 
-// Len returns the length of the Queue.
+// call executes the task's function.
+func (t *task) call(ctx context.Context) error {
+	if fctx := t.fctx(); fctx != nil {
+		return fctx(ctx)
+	}
+	return t.f()
+}
+
+// exit is called by runners leaving after execution has stopped.
+// The last runner to leave returns recovered tasks to the queue.
+// mutex must be held
+func (q *Queue) exit() {
+	q.running--
+	if q.running == 0 {
+		// We're last
+		q.parseRecovered()
+		q.haltError = q.halted()
+		q.halts++
+		q.remember(q.haltError)
+		if q.metrics != nil {
+			atomic.AddUint64(&q.metrics.halts, 1)
+		}
+		if h := q.hooks; h != nil && h.OnHalt != nil {
+			err := q.err()
+			q.mutex.Unlock()
+			h.OnHalt(err)
+			q.mutex.Lock()
+		}
+	}
+}
+
+// Len returns the length of the Queue, including keyed tasks waiting for an earlier task with the same key.
 // It does not count currently executing tasks, and might increase without calls to Do as
 // Run calls may return unexecuted tasks back to the queue if an error is encountered.
 func (q *Queue) Len() int {
 	q.mutex.Lock()
-	l := q.len()
+	l := q.len() + q.waiting
 	q.mutex.Unlock()
 	return l
 }
@@ -218,12 +400,153 @@ func (q *Queue) IsIdle() bool {
 // Sucessful tasks are never executed more than once, are not lost on errors and are always executed in order.
 // If execution is halted by an error, all unexecuted tasks including the task that created the error are returned to the queue,
 // taking care to respect order. The error can then be handled, and tasks resumed by calling Run again.
-// SkipOne is useful for skipping the errored task if needed.
+// SkipErrored is useful for skipping the errored task if needed.
 func (q *Queue) Do(f ...func() error) {
+	if !q.send(f) {
+		q.DoPriority(0, f...)
+	}
+}
+
+// DoPriority adds tasks to the Queue with the given priority.
+// Runners take tasks with a higher priority before those with a lower one,
+// and tasks of the same priority are executed in order as with Do.
+// Priority only affects which tasks a runner takes next;
+// tasks already taken by a runner are not interrupted by the addition of higher priority tasks.
+func (q *Queue) DoPriority(p int, f ...func() error) {
+	q.DoOptions(TaskOptions{Priority: p}, f...)
+}
+
+// TaskOptions configures tasks added with DoOptions.
+type TaskOptions struct {
+	// Priority of the tasks, see DoPriority.
+	Priority int
+
+	// Retry is the retry policy for the tasks.
+	// If nil, the Queue's retry policy is used.
+	Retry *RetryPolicy
+
+	// Key serialises the tasks with other tasks with the same key if not nil, see DoKeyed.
+	// It must be comparable.
+	Key interface{}
+
+	// Label identifies the tasks in errors, see KeyLabel.
+	Label string
+
+	// Class is the class the tasks are fairly scheduled in, see Weight.
+	Class string
+
+	// Timeout limits the execution time of context-aware tasks, see DoWithTimeout.
+	// If 0, the Queue's default timeout is used, and if negative, the tasks have no timeout.
+	Timeout time.Duration
+
+	remote *remoteTask // task for remote workers, see Server.Do
+}
+
+// DoOptions adds tasks to the Queue, configured by o.
+func (q *Queue) DoOptions(o TaskOptions, f ...func() error) {
+	q.add(o, len(f), func(buff []task) {
+		for i := range buff {
+			buff[i].f = f[i]
+		}
+	})
+}
+
+// DoCtx adds tasks that are given the Queue's context to the Queue.
+// The context is cancelled when execution stops, such as when Cancel or Reset is called or another task fails,
+// allowing tasks to return early. A task that returns an error after its context is cancelled
+// is treated as if it was never executed, and is returned to the queue.
+func (q *Queue) DoCtx(f ...func(context.Context) error) {
+	q.DoOptionsCtx(TaskOptions{}, f...)
+}
+
+// DoOptionsCtx adds tasks that are given the Queue's context to the Queue, configured by o.
+// See DoCtx.
+func (q *Queue) DoOptionsCtx(o TaskOptions, f ...func(context.Context) error) {
+	q.add(o, len(f), func(buff []task) {
+		for i := range buff {
+			buff[i].extend().fctx = f[i]
+		}
+	})
+}
+
+// adds n tasks to the queue, calling fill to set their functions, handles and callbacks.
+// fill is called with the mutex held, and IDs already set.
+// If the queue is full, it waits for space.
+func (q *Queue) add(o TaskOptions, n int, fill func(buff []task)) {
+	q.insert(nil, addWait, o, n, fill)
+}
+
+// insert is add, with mode controlling what happens when the queue is full.
+// If ctx finishes while waiting for space, its error is returned. A nil ctx waits indefinitely.
+func (q *Queue) insert(ctx context.Context, mode addMode, o TaskOptions, n int, fill func(buff []task)) error {
+	if n == 0 {
+		return nil
+	}
+
 	q.mutex.Lock()
-	copy(q.queue[q.grow(len(f)):], f)
+	if mode != addForce && !q.fits(n) {
+		if mode == addFail {
+			q.mutex.Unlock()
+			return ErrQueueFull
+		}
+		if err := q.waitSpace(ctx, n); err != nil {
+			q.mutex.Unlock()
+			return err
+		}
+	}
+	q.put(o, n, fill)
+	return nil
+}
+
+// put adds n tasks to the queue regardless of its capacity, calling fill as add does, and unlocks the mutex.
+// mutex must be held
+func (q *Queue) put(o TaskOptions, n int, fill func(buff []task)) {
+	// Tasks added by Do before these come first.
+	q.drain()
+
+	var buff []task
+	if o.Key != nil {
+		buff = make([]task, n)
+	} else {
+		buff = q.lane(o.Priority, o.Class, o.remote != nil).grow(n)
+	}
+
+	obs := observer{q.hooks, q.metrics}
+	g := plainGroup
+	if o.Priority != 0 || o.Retry != nil || o.Key != nil || o.Label != "" || o.Class != "" || o.Timeout != 0 || o.remote != nil || obs.active() {
+		g = &taskGroup{
+			priority: o.Priority,
+			retry:    o.Retry,
+			key:      o.Key,
+			label:    o.Label,
+			class:    o.Class,
+			timeout:  o.Timeout,
+			remote:   o.remote,
+		}
+		// Only hooks and metrics need the time, which is costly to read for every addition.
+		if obs.active() {
+			g.enqueued = time.Now()
+		}
+	}
+	for i := range buff {
+		q.seq++
+		buff[i].seq, buff[i].group = q.seq, g
+	}
+	fill(buff)
+
+	if o.Key != nil {
+		for i := range buff {
+			buff[i].extend().id = buff[i].seq
+		}
+		q.addKeyed(o.Key, buff)
+	}
+	if o.Label != "" {
+		q.count(buff, 1)
+	}
+
 	q.mutex.Unlock()
 	q.cond.Broadcast()
+	obs.enqueue(n)
 }
 
 // SkipErrored skips the task which produced the error after execution was halted.
@@ -231,22 +554,19 @@ func (q *Queue) Do(f ...func() error) {
 func (q *Queue) SkipErrored() bool {
 	q.mutex.Lock()
 	q.waitExit()
-	l := q.len()
-	if l <= 0 || q.errIndex < 0 {
-		q.mutex.Unlock()
-		return false
-	}
-
-	if q.errIndex > l || q.errIndex <= 0 {
+	if q.errSeq == 0 {
 		q.mutex.Unlock()
 		return false
 	}
 
-	copy(q.queue[q.off+1:q.off+q.errIndex], q.queue[q.off:q.off+q.errIndex-1])
-	q.off++
-	q.errIndex = 0
+	skipped := q.remove(map[uint64]bool{q.errSeq: true})
+	q.errSeq = 0
+	q.freed()
 	q.mutex.Unlock()
-	return true
+	for i := range skipped {
+		q.done(&skipped[i], TaskSkipped, nil)
+	}
+	return len(skipped) > 0
 }
 
 // Cancel stops execution of tasks.
@@ -265,14 +585,24 @@ func (q *Queue) cancel() {
 }
 
 // Err returns an error explaining why execution has halted.
-// If there is no error, and wait is true, Err will block until an error occours.
+// If execution was halted by failing tasks, it returns the errors of every task that failed before execution stopped
+// as Errors.Get would, each with KeyID set, and KeyLabel if the task has a label.
+// If there is no error, and wait is true, Err will block until an error occours,
+// and if execution is halting, it waits for runners to stop so that every failure is included.
 // Calling Run again will remove the error and resume execution.
 func (q *Queue) Err(wait bool) error {
 	q.mutex.Lock()
 	q.init()
-	err := q.err()
-	for ; err == nil && wait; err = q.err() {
+	halts := q.halts
+	err := q.halted()
+	for wait && (err == nil || q.running > 0) {
 		q.cond.Wait()
+		if q.halts != halts {
+			// Execution may have been resumed before we woke.
+			err = q.haltError
+			break
+		}
+		err = q.halted()
 	}
 	q.mutex.Unlock()
 	return err
@@ -286,7 +616,7 @@ func (q *Queue) err() error {
 	return q.ctx.Err()
 }
 
-// Reset stops execution of tasks, clears the Queue and sets the Context.
+// Reset stops execution of tasks, clears the Queue, schedules and recorded failures, and sets the Context.
 // If a task is executing, it blocks until it returns.
 // A nil context is valid.
 func (q *Queue) Reset(ctx context.Context) {
@@ -295,8 +625,16 @@ func (q *Queue) Reset(ctx context.Context) {
 	q.waitExit()
 	q.context(ctx)
 	q.exitError = nil
-	q.clearQueue()
+	q.failures = nil
+	skipped := q.clearQueue()
+	if q.sched != nil {
+		q.sched.clear()
+	}
+	q.freed()
 	q.mutex.Unlock()
+	for i := range skipped {
+		q.done(&skipped[i], TaskSkipped, nil)
+	}
 }
 
 // Context sets the context for the Queue. It doesn't impact the queue or execution.
@@ -305,6 +643,7 @@ func (q *Queue) Context(ctx context.Context) {
 	q.mutex.Lock()
 	q.context(ctx)
 	q.mutex.Unlock()
+	q.cond.Broadcast()
 }
 
 // Set context
@@ -318,16 +657,23 @@ func (q *Queue) context(ctx context.Context) {
 		q.ctxCancel()
 	}
 	q.ctx, q.ctxCancel = context.WithCancel(ctx)
+	done := q.ctx.Done()
 	go func() {
-		<-q.ctx.Done()
+		<-done
 		q.cond.Broadcast()
 	}()
 }
 
 // Wait waits until the Queue is either caught up or errored, returning the error if encountered.
+// If the Queue continues on error and hasn't halted, it returns the failures recorded since the last call to Wait
+// as Errors.Get would.
 func (q *Queue) Wait() error {
 	q.mutex.Lock()
 	err := q.wait()
+	if err == nil {
+		err = q.failures.Get()
+		q.failures = nil
+	}
 	q.mutex.Unlock()
 	return err
 }
@@ -349,12 +695,32 @@ func (q *Queue) waitExit() {
 	}
 }
 
+// Resume clears the error and resumes execution after the Queue has halted.
+// Run and RunQueued resume execution themselves, so it is only needed
+// to resume runners waiting for the error to clear, such as those started by Start.
+func (q *Queue) Resume() {
+	q.mutex.Lock()
+	q.init()
+	if q.err() != nil {
+		q.resume()
+	}
+	q.mutex.Unlock()
+}
+
 func (q *Queue) resume() {
-	q.running--
 	q.waitExit()
 	q.exitError = nil
+	q.failed = nil
 	q.context(q.originalCtx)
 	q.cond.Broadcast()
+	if q.metrics != nil {
+		atomic.AddUint64(&q.metrics.resumes, 1)
+	}
+	if h := q.hooks; h != nil && h.OnResume != nil {
+		q.mutex.Unlock()
+		h.OnResume()
+		q.mutex.Lock()
+	}
 }
 
 // Called before using cond.
@@ -364,108 +730,486 @@ func (q *Queue) init() {
 	})
 }
 
-// grows the function buffer by n,
-// returning the index where new functions should be written.
+// lane returns the lane for priority p and class, for remote workers if remote is true, creating it if needed.
 // mutex must be held
-func (q *Queue) grow(n int) int {
-	c, l := cap(q.queue), len(q.queue)
-	if c-l >= n {
-		q.queue = q.queue[:l+n]
-		return l
+func (q *Queue) lane(p int, class string, remote bool) *lane {
+	i := sort.Search(len(q.lanes), func(i int) bool {
+		l := q.lanes[i]
+		if l.remote != remote {
+			return l.remote
+		}
+		return l.priority < p || (l.priority == p && l.class >= class)
+	})
+	if i < len(q.lanes) && q.lanes[i].priority == p && q.lanes[i].class == class && q.lanes[i].remote == remote {
+		return q.lanes[i]
 	}
 
-	if l == 0 {
-		q.queue = make([]func() error, n, n*2)
-		return 0
-	}
+	l := &lane{priority: p, class: class, remote: remote}
+	q.lanes = append(q.lanes, nil)
+	copy(q.lanes[i+1:], q.lanes[i:])
+	q.lanes[i] = l
+	return l
+}
 
-	if n <= (c/2)-(l-q.off) {
-		u := copy(q.queue, q.queue[q.off:])
-		q.queue = q.queue[:u+n]
-		q.off = 0
-		return u
+// removes empty lanes, so that lanes don't accumulate for every priority and class ever used.
+// An empty lane has no turn in progress, see fair, so removing it doesn't change the order tasks are taken in.
+// mutex must be held
+func (q *Queue) prune() {
+	k := 0
+	for _, l := range q.lanes {
+		if l.len() > 0 {
+			q.lanes[k] = l
+			k++
+			continue
+		}
+		for l.head < len(l.segs) {
+			l.drop()
+		}
 	}
+	if k == len(q.lanes) {
+		return
+	}
+	for i := k; i < len(q.lanes); i++ {
+		q.lanes[i] = nil
+	}
+	q.lanes = q.lanes[:k]
 
-	nq := make([]func() error, (l-q.off)+n, c*2+n)
-	u := copy(nq, q.queue[q.off:])
-	q.queue = nq[:u+n]
-	q.off = 0
-	return u
+	for t := range q.turns {
+		i := sort.Search(len(q.lanes), func(i int) bool {
+			l := q.lanes[i]
+			if l.remote != t.remote {
+				return l.remote
+			}
+			return l.priority <= t.priority
+		})
+		if i == len(q.lanes) || q.lanes[i].priority != t.priority || q.lanes[i].remote != t.remote {
+			delete(q.turns, t)
+		}
+	}
 }
 
+// clears the queue, returning removed tasks that need to be notified.
 // mutex must be held.
-func (q *Queue) clearQueue() {
-	q.queue = q.queue[:0]
-	q.off = 0
-	q.errIndex = 0
+func (q *Queue) clearQueue() (skipped []task) {
+	q.drain()
+	for _, l := range q.lanes {
+		for _, s := range l.segs[l.head:] {
+			for i := s.off; i < len(s.tasks); i++ {
+				if s.tasks[i].notifies() {
+					skipped = append(skipped, s.tasks[i])
+				}
+			}
+		}
+	}
+	q.lanes, q.turns = nil, nil
+	for i := range q.recover {
+		if q.recover[i].notifies() {
+			skipped = append(skipped, q.recover[i])
+		}
+		q.recover[i] = task{}
+	}
+	for _, k := range q.keys {
+		for i := range k.waiting {
+			if k.waiting[i].notifies() {
+				skipped = append(skipped, k.waiting[i])
+			}
+		}
+	}
+	q.keys = nil
+	q.waiting = 0
+	q.labels = nil
+	q.errSeq = 0
+	q.failed = nil
 	q.recover = q.recover[:0]
+	return skipped
 }
 
-// unread portion of buffer
-func (q *Queue) len() int { return len(q.queue) - q.off }
+// number of queued tasks
+// mutex must be held
+func (q *Queue) len() (n int) {
+	q.drain()
+	for _, l := range q.lanes {
+		n += l.len()
+	}
+	return n
+}
 
-func (q *Queue) getTasks(buff []func() error) (int, int) {
-	c := copy(buff, q.queue[q.off:])
-	q.count++
-	q.errIndex = 0
-	q.off += c
-	return c, q.count
+// number of queued tasks for local runners, or for remote workers if remote is true.
+// mutex must be held
+func (q *Queue) ready(remote bool) (n int) {
+	for _, l := range q.kind(remote) {
+		n += l.len()
+	}
+	return n
 }
 
-func (q *Queue) returnTasks(buff []func() error, count int) {
-	pos := q.count - count
-	if l := len(q.recover); l <= pos {
-		nr := make([][]func() error, pos+1)
-		copy(nr, q.recover)
-		nr[pos] = buff
-		q.recover = nr
-	} else {
-		q.recover[pos] = buff
+// returns the lanes for local runners, or for remote workers if remote is true.
+// mutex must be held
+func (q *Queue) kind(remote bool) []*lane {
+	q.drain()
+	// Lanes for remote workers follow those for local runners.
+	i := sort.Search(len(q.lanes), func(i int) bool {
+		return q.lanes[i].remote
+	})
+	if remote {
+		return q.lanes[i:]
+	}
+	return q.lanes[:i]
+}
+
+// fills buff with tasks, highest priority first.
+func (q *Queue) getTasks(buff []task, remote bool) int {
+	q.errSeq = 0
+	lanes := q.kind(remote)
+	var c int
+	for i := 0; i < len(lanes) && c < len(buff); {
+		j := i + 1
+		for j < len(lanes) && lanes[j].priority == lanes[i].priority {
+			j++
+		}
+
+		var n int
+		if j-i == 1 {
+			n = lanes[i].pop(buff[c:])
+		} else {
+			n = q.fair(lanes[i:j], buff[c:])
+		}
+		if q.labels != nil {
+			q.count(buff[c:c+n], -1)
+		}
+		c += n
+		i = j
+	}
+	q.prune()
+	return c
+}
+
+// returns tasks a runner is leaving without executing to the queue.
+// mutex must be held
+func (q *Queue) abandon(tasks []task) {
+	q.returnTasks(tasks)
+	if q.err() != nil {
+		q.exit()
+		return
 	}
+	// Execution continues, so they can be returned immediately.
+	q.parseRecovered()
+	q.running--
 }
 
-func (q *Queue) failTask(buff []func() error, count int) {
-	q.returnTasks(buff, count)
-	pos := q.count - count
-	q.errCountInd = pos + 1
+func (q *Queue) returnTasks(buff []task) {
+	q.recover = append(q.recover, buff...)
+	if q.labels != nil {
+		q.count(buff, 1)
+	}
 }
 
+// returns recovered tasks to their lanes in their original order.
 func (q *Queue) parseRecovered() {
-	q.errIndex = 0
-	for i := range q.recover {
-		l := len(q.recover[i])
+	r := q.recover
+	sort.Slice(r, func(i, j int) bool {
+		if (r[i].group.remote != nil) != (r[j].group.remote != nil) {
+			return r[j].group.remote != nil
+		}
+		if r[i].group.priority != r[j].group.priority {
+			return r[i].group.priority > r[j].group.priority
+		}
+		if r[i].group.class != r[j].group.class {
+			return r[i].group.class < r[j].group.class
+		}
+		return r[i].seq < r[j].seq
+	})
+
+	for i := 0; i < len(r); {
+		j := i + 1
+		for j < len(r) && r[j].group.priority == r[i].group.priority && r[j].group.class == r[i].group.class && (r[j].group.remote != nil) == (r[i].group.remote != nil) {
+			j++
+		}
+		l := q.lane(r[i].group.priority, r[i].group.class, r[i].group.remote != nil)
+		l.restore(r[i:j])
+		i = j
+	}
+
+	for i := range r {
+		r[i] = task{}
+	}
+	q.recover = r[:0]
+}
+
+// lane is a queue of tasks sharing the same priority and class.
+// Tasks are stored in segments, so adding tasks to either end never moves queued tasks,
+// and memory is released as the lane is emptied.
+type lane struct {
+	priority int
+	class    string
+	remote   bool       // tasks for remote workers
+	deficit  int        // tasks left in the lane's turn, see fair
+	segs     []*segment // segments in order, from head
+	head     int        // index in segs of the first segment
+	n        int        // number of tasks
+}
 
-		copy(q.queue[q.growLeft(l):], q.recover[i])
+// segment is a contiguous part of a lane.
+// Tasks added without options by Do are stored as just their functions, with consecutive sequence numbers from seq,
+// until something other than taking them from the front of the lane needs them as tasks, see expand.
+type segment struct {
+	tasks []task
+	funcs []func() error // functions of plain tasks, used instead of tasks if not nil
+	seq   uint64         // sequence number of funcs[0]
+	off   int            // tasks or funcs index for reading
+}
 
-		if i == q.errCountInd-1 {
-			q.errIndex = 1
-			q.errCountInd = 0
+// unread portion of tasks
+func (s *segment) len() int {
+	if s.funcs != nil {
+		return len(s.funcs) - s.off
+	}
+	return len(s.tasks) - s.off
+}
+
+// returns the index in tasks of the task with sequence number seq.
+func (s *segment) search(seq uint64) (int, bool) {
+	i := s.off + sort.Search(s.len(), func(i int) bool {
+		return s.tasks[s.off+i].seq >= seq
+	})
+	return i, i < len(s.tasks) && s.tasks[i].seq == seq
+}
+
+// reports whether a segment of plain tasks holds the task with sequence number seq.
+func (s *segment) covers(seq uint64) bool {
+	return seq >= s.seq+uint64(s.off) && seq < s.seq+uint64(len(s.funcs))
+}
+
+// stores plain tasks as tasks, so they can be searched, removed and returned to.
+func (s *segment) expand() {
+	if s.funcs == nil {
+		return
+	}
+	if cap(s.funcs) == segmentSize {
+		s.tasks = segments.Get().(*segment).tasks[:len(s.funcs)]
+	} else {
+		s.tasks = make([]task, len(s.funcs))
+	}
+	s.unpack(s.tasks[s.off:])
+	f := s.funcs
+	s.funcs = nil
+	if cap(f) == segmentSize {
+		for i := range f {
+			f[i] = nil
 		}
+		plainSegments.Put(&segment{funcs: f[:0]})
 	}
-	q.recover = q.recover[:0]
 }
 
-// returns new read offset
-func (q *Queue) growLeft(n int) int {
-	if q.errIndex > 0 {
-		q.errIndex += n
+// writes plain tasks from the front of the segment to buff, returning the number written.
+func (s *segment) unpack(buff []task) int {
+	n := len(s.funcs) - s.off
+	if n > len(buff) {
+		n = len(buff)
+	}
+	for i := range buff[:n] {
+		// Runners reuse their buffers, so most tasks already have the right group.
+		t := &buff[i]
+		t.f, t.seq = s.funcs[s.off+i], s.seq+uint64(s.off+i)
+		if t.group != plainGroup {
+			t.group = plainGroup
+		}
+		if t.ext != nil {
+			t.ext = nil
+		}
+	}
+	return n
+}
+
+// number of tasks in the lane
+func (l *lane) len() int { return l.n }
+
+// grows the lane by n at the end,
+// returning the space where new tasks should be written.
+func (l *lane) grow(n int) []task {
+	l.n += n
+	if l.head < len(l.segs) {
+		s := l.segs[len(l.segs)-1]
+		if ln := len(s.tasks); s.funcs == nil && cap(s.tasks)-ln >= n {
+			s.tasks = s.tasks[:ln+n]
+			return s.tasks[ln:]
+		}
+	}
+
+	s := l.segment(n)
+	s.tasks = s.tasks[:n]
+	l.segs = append(l.segs, s)
+	return s.tasks
+}
+
+// adds plain tasks with the functions f and consecutive sequence numbers from seq to the end of the lane.
+func (l *lane) add(f []func() error, seq uint64) {
+	if l.head < len(l.segs) {
+		s := l.segs[len(l.segs)-1]
+		ln := len(s.funcs)
+		if s.funcs != nil && cap(s.funcs)-ln >= len(f) && s.seq+uint64(ln) == seq {
+			s.funcs = append(s.funcs, f...)
+			l.n += len(f)
+			return
+		}
+		// Tasks with options are usually added between these, so keep them together rather than fragmenting the lane.
+		if s.funcs == nil && cap(s.tasks)-len(s.tasks) >= len(f) {
+			buff := l.grow(len(f))
+			for i := range buff {
+				buff[i] = task{f: f[i], seq: seq + uint64(i), group: plainGroup}
+			}
+			return
+		}
+	}
+
+	var s *segment
+	if len(f) <= segmentSize {
+		s = plainSegments.Get().(*segment)
+	} else {
+		s = &segment{funcs: make([]func() error, 0, len(f))}
 	}
+	s.funcs, s.seq = append(s.funcs, f...), seq
+	l.segs = append(l.segs, s)
+	l.n += len(f)
+}
 
-	if q.off >= n {
-		q.off -= n
-		return q.off
+// grows the lane by n at the front,
+// returning the space where returned tasks should be written.
+func (l *lane) growLeft(n int) []task {
+	l.n += n
+	if l.head < len(l.segs) {
+		s := l.segs[l.head]
+		if s.funcs == nil && s.off >= n {
+			s.off -= n
+			return s.tasks[s.off : s.off+n]
+		}
 	}
 
-	u, c := q.len(), cap(q.queue)
-	var nq []func() error
-	if c-u >= n {
-		nq = q.queue[:u+n]
+	// Fill the new segment from the end, leaving space for more returned tasks.
+	s := l.segment(n)
+	s.tasks = s.tasks[:cap(s.tasks)]
+	s.off = len(s.tasks) - n
+	if l.head > 0 {
+		l.head--
+		l.segs[l.head] = s
 	} else {
-		nq = make([]func() error, u+n, (c*2)+n)
+		l.segs = append(l.segs, nil)
+		copy(l.segs[1:], l.segs)
+		l.segs[0] = s
 	}
+	return s.tasks[s.off:]
+}
+
+// returns tasks to the lane in order of their sequence numbers.
+// Tasks are usually returned before every task in the lane, but not if other tasks were returned while they were taken.
+func (l *lane) restore(tasks []task) {
+	var ahead []task
+	for {
+		t := l.peek()
+		if t == nil || t.seq > tasks[len(tasks)-1].seq {
+			break
+		}
+		ahead = append(ahead, task{})
+		l.pop(ahead[len(ahead)-1:])
+	}
+	if len(ahead) > 0 {
+		merged := make([]task, 0, len(tasks)+len(ahead))
+		for len(tasks) > 0 && len(ahead) > 0 {
+			if tasks[0].seq < ahead[0].seq {
+				merged, tasks = append(merged, tasks[0]), tasks[1:]
+			} else {
+				merged, ahead = append(merged, ahead[0]), ahead[1:]
+			}
+		}
+		tasks = append(append(merged, tasks...), ahead...)
+	}
+	copy(l.growLeft(len(tasks)), tasks)
+}
+
+// returns the first task in the lane, or nil if it is empty.
+func (l *lane) peek() *task {
+	for _, s := range l.segs[l.head:] {
+		if s.len() > 0 {
+			s.expand()
+			return &s.tasks[s.off]
+		}
+	}
+	return nil
+}
+
+// moves tasks from the front of the lane to buff, returning the number moved.
+func (l *lane) pop(buff []task) int {
+	var c int
+	for c < len(buff) && l.head < len(l.segs) {
+		s := l.segs[l.head]
+		var n int
+		if s.funcs != nil {
+			n = s.unpack(buff[c:])
+		} else {
+			n = copy(buff[c:], s.tasks[s.off:])
+		}
+		s.off += n
+		c += n
+		if s.len() > 0 {
+			break
+		}
+		l.drop()
+	}
+	l.n -= c
+	return c
+}
+
+// removes the first segment, which must be empty.
+func (l *lane) drop() {
+	s := l.segs[l.head]
+	l.segs[l.head] = nil
+	l.head++
+	switch {
+	case l.head == len(l.segs):
+		l.segs, l.head = l.segs[:0], 0
+	case l.head > len(l.segs)/2:
+		n := copy(l.segs, l.segs[l.head:])
+		for i := n; i < len(l.segs); i++ {
+			l.segs[i] = nil
+		}
+		l.segs, l.head = l.segs[:n], 0
+	}
+
+	// Only reuse segments of the usual size, so a burst of tasks doesn't hold on to memory.
+	switch {
+	case cap(s.funcs) == segmentSize:
+		for i := range s.funcs {
+			s.funcs[i] = nil
+		}
+		s.funcs, s.off = s.funcs[:0], 0
+		plainSegments.Put(s)
+	case s.funcs == nil && cap(s.tasks) == segmentSize:
+		for i := range s.tasks {
+			s.tasks[i] = task{}
+		}
+		s.tasks, s.off = s.tasks[:0], 0
+		segments.Put(s)
+	}
+}
+
+// emptied segments of segmentSize
+var segments = sync.Pool{
+	New: func() interface{} {
+		return &segment{tasks: make([]task, 0, segmentSize)}
+	},
+}
+
+// emptied segments of segmentSize plain tasks
+var plainSegments = sync.Pool{
+	New: func() interface{} {
+		return &segment{funcs: make([]func() error, 0, segmentSize)}
+	},
+}
 
-	copy(nq[n:], q.queue[q.off:])
-	q.queue = nq
-	q.off = 0
-	return q.off
+// returns an empty segment with space for at least n tasks.
+func (l *lane) segment(n int) *segment {
+	if n <= segmentSize {
+		return segments.Get().(*segment)
+	}
+	return &segment{tasks: make([]task, 0, n)}
 }
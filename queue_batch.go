@@ -0,0 +1,74 @@
+package things
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const batchTime = 10 * time.Millisecond // target execution time of a batch
+
+// MaxBatch sets the maximum number of tasks a runner takes from the queue at a time. If n is 0 or negative,
+// the default of 2048 is used.
+//
+// Runners take fewer tasks than the maximum when tasks are slow to execute, or when other runners are waiting for tasks,
+// so that a runner doesn't hold on to tasks that idle runners could be executing.
+// Taking more tasks at a time reduces contention between runners, but delays tasks behind those being executed
+// if execution halts, and makes uneven work between runners more likely.
+func (q *Queue) MaxBatch(n int) {
+	if n < 0 {
+		n = 0
+	}
+	q.mutex.Lock()
+	q.maxBatch = n
+	q.mutex.Unlock()
+}
+
+// returns the number of tasks a runner should take, at most n if n is positive.
+// mutex must be held
+func (q *Queue) batchSize(n int) int {
+	size := q.maxBatch
+	if size == 0 {
+		size = buffSize
+	}
+
+	// Take tasks that will execute in about batchTime.
+	if q.taskTime > 0 {
+		if b := batchTime / q.taskTime; b < time.Duration(size) {
+			size = int(b)
+		}
+	}
+
+	// Leave tasks for runners waiting for them.
+	if idle := len(q.runners) - q.running; idle > 0 {
+		if share := (q.len() + idle) / (idle + 1); share < size {
+			size = share
+		}
+	}
+
+	if n > 0 && n < size {
+		size = n
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+// records the time a runner spent executing its batch.
+// mutex must be held
+func (q *Queue) observe(r *runner, d time.Duration) {
+	t := d / time.Duration(atomic.LoadInt32(&r.current)+1)
+	if q.taskTime == 0 {
+		q.taskTime = t
+		return
+	}
+	q.taskTime += (t - q.taskTime) / 4
+}
+
+// returns the runner's buffer, with space for n tasks.
+func (r *runner) buffer(n int) []task {
+	if cap(r.buff) < n {
+		r.buff = make([]task, n)
+	}
+	return r.buff[:n]
+}
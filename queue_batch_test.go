@@ -0,0 +1,47 @@
+package things
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueue_MaxBatch(t *testing.T) {
+	var q *Queue
+	var batches []int
+	record := func() error {
+		batches = append(batches, q.Snapshot().Runners[0].Batch)
+		return nil
+	}
+
+	q = NewQueue(nil)
+	q.MaxBatch(3)
+	for i := 0; i < 7; i++ {
+		q.Do(record)
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 2, 1, 3, 2, 1, 1}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batch sizes were %v, wanted %v", batches, want)
+	}
+
+	// Slow tasks are taken one at a time.
+	q = NewQueue(nil)
+	slow := func() error {
+		time.Sleep(2 * batchTime)
+		return nil
+	}
+	q.Do(slow, slow)
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+	batches = nil
+	q.Do(record, record, record)
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 1, 1}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batch sizes after slow tasks were %v, wanted %v", batches, want)
+	}
+}
@@ -0,0 +1,104 @@
+package things
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrQueueFull is returned by TryDo when the Queue is at capacity.
+var ErrQueueFull = errors.New("queue is full")
+
+// how insert behaves when the queue is full.
+type addMode int
+
+const (
+	addWait  addMode = iota // wait for space
+	addFail                 // return ErrQueueFull
+	addForce                // ignore the capacity
+)
+
+// Capacity limits the number of queued tasks, including keyed tasks waiting for an earlier task with the same key.
+// Executing tasks are not counted. Once the Queue is full, Do and the other methods adding tasks block until
+// runners have taken enough tasks to make space, DoWait blocks until space is available or its context finishes,
+// and TryDo returns ErrQueueFull.
+// Adding more tasks than the capacity at once waits for the Queue to empty, then adds them all.
+// A capacity of 0 or less removes the limit. It can be changed at any time.
+//
+// Tasks that add tasks to a full Queue block the runner executing them,
+// and can deadlock the Queue if every runner is blocked.
+func (q *Queue) Capacity(n int) {
+	if n < 0 {
+		n = 0
+	}
+	q.mutex.Lock()
+	q.capacity = n
+	q.direct()
+	q.freed()
+	q.mutex.Unlock()
+}
+
+// TryDo adds tasks to the Queue as with Do, returning ErrQueueFull without adding any if there isn't space for all of them.
+func (q *Queue) TryDo(f ...func() error) error {
+	return q.insert(nil, addFail, TaskOptions{}, len(f), func(buff []task) {
+		for i := range buff {
+			buff[i].f = f[i]
+		}
+	})
+}
+
+// DoWait adds tasks to the Queue as with Do, waiting for space if the Queue is full.
+// If ctx finishes first, no tasks are added and its error is returned.
+// A nil context is valid.
+func (q *Queue) DoWait(ctx context.Context, f ...func() error) error {
+	return q.insert(ctx, addWait, TaskOptions{}, len(f), func(buff []task) {
+		for i := range buff {
+			buff[i].f = f[i]
+		}
+	})
+}
+
+// reports whether n more tasks can be queued.
+// mutex must be held
+func (q *Queue) fits(n int) bool {
+	l := q.len() + q.waiting
+	return q.capacity == 0 || l == 0 || l+n <= q.capacity
+}
+
+// waits until n tasks fit in the queue, or ctx finishes.
+// mutex must be held
+func (q *Queue) waitSpace(ctx context.Context, n int) error {
+	q.init()
+	if ctx != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				q.mutex.Lock()
+				q.cond.Broadcast()
+				q.mutex.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	q.blocked++
+	defer func() {
+		q.blocked--
+	}()
+	for !q.fits(n) {
+		if ctx != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		q.cond.Wait()
+	}
+	return nil
+}
+
+// wakes producers waiting for space.
+// mutex must be held
+func (q *Queue) freed() {
+	if q.blocked > 0 {
+		q.cond.Broadcast()
+	}
+}
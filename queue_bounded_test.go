@@ -0,0 +1,63 @@
+package things
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue_Capacity(t *testing.T) {
+	q := NewQueue(nil)
+	q.Capacity(2)
+	nop := func() error { return nil }
+
+	if err := q.TryDo(nop, nop); err != nil {
+		t.Fatalf("TryDo() = %v, want nil", err)
+	}
+	if err := q.TryDo(nop); err != ErrQueueFull {
+		t.Fatalf("TryDo() on full queue = %v, want ErrQueueFull", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.DoWait(ctx, nop); err != context.DeadlineExceeded {
+		t.Fatalf("DoWait() on full queue = %v, want context.DeadlineExceeded", err)
+	}
+
+	added := make(chan struct{})
+	go func() {
+		q.Do(nop)
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("Do returned on a full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if _, err := q.RunQueued(1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Do didn't return after space was made")
+	}
+	if l := q.Len(); l != 2 {
+		t.Errorf("Len() = %v, want 2", l)
+	}
+
+	// Removing the capacity wakes blocked producers.
+	added = make(chan struct{})
+	go func() {
+		q.Do(nop, nop)
+		close(added)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Capacity(0)
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Do didn't return after the capacity was removed")
+	}
+}
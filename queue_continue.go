@@ -0,0 +1,43 @@
+package things
+
+// Keys set on the errors of failed tasks returned by Err, and recorded when the Queue continues on error.
+const (
+	// KeyID is the TaskID of the failed task. See DoID.
+	KeyID ErrKey = "id"
+
+	// KeyLabel is the label of the failed task, if it has one. See TaskOptions.Label.
+	KeyLabel ErrKey = "label"
+)
+
+// ContinueOnError sets whether failing tasks halt execution.
+// If enabled, a task that fails, after any retries, is not returned to the queue.
+// Its error is recorded with KeyID and KeyLabel set as returned by Err, and execution continues with the next task.
+// Wait returns the recorded errors, and Run and RunQueued only return if execution is stopped by other means.
+// A failed keyed task releases its key as if it had succeeded.
+// Changes apply to tasks taken by runners after the call.
+func (q *Queue) ContinueOnError(enabled bool) {
+	q.mutex.Lock()
+	q.cont = enabled
+	q.mutex.Unlock()
+}
+
+// records the error of a failed task.
+// mutex must not be held
+func (q *Queue) record(t *task, err *error) {
+	*err = t.position(*err)
+	q.mutex.Lock()
+	q.failures = append(q.failures, *err)
+	q.mutex.Unlock()
+}
+
+// position wraps err with the task's ID and label.
+func (t *task) position(err error) error {
+	we := WrappedError{
+		Err:    err,
+		Values: map[interface{}]interface{}{KeyID: TaskID(t.id())},
+	}
+	if t.group.label != "" {
+		we.Values[KeyLabel] = t.group.label
+	}
+	return we
+}
@@ -0,0 +1,59 @@
+package things
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestQueue_ContinueOnError(t *testing.T) {
+	q := NewQueue(nil)
+	q.ContinueOnError(true)
+
+	errFail := errors.New("fail")
+	var ran int
+	q.Do(
+		func() error { ran++; return nil },
+		func() error { ran++; return errFail },
+	)
+	h := q.DoHandle(TaskOptions{Label: "labelled"}, func() error { ran++; return errFail })
+	q.Do(func() error { ran++; return nil })
+
+	if n, err := q.RunQueued(0); err != nil || n != 4 {
+		t.Fatalf("RunQueued(0) = %v, %v; wanted 4, nil", n, err)
+	}
+	if ran != 4 {
+		t.Errorf("executed %v tasks, wanted 4", ran)
+	}
+
+	err := q.Wait()
+	el, ok := err.(Errors)
+	if !ok || len(el) != 2 {
+		t.Fatalf("Wait() = %v, wanted 2 errors", err)
+	}
+	for i, id := range []TaskID{2, h[0].ID()} {
+		if !ErrIs(el[i], errFail) {
+			t.Errorf("error %v is %v, wanted %v", i, el[i], errFail)
+		}
+		if v, _ := ErrGet(el[i], KeyID); v != id {
+			t.Errorf("error %v has ID %v, wanted %v", i, v, id)
+		}
+	}
+	if _, ok := ErrGet(el[0], KeyLabel); ok {
+		t.Errorf("unlabelled task's error has a label")
+	}
+	if v, _ := ErrGet(el[1], KeyLabel); v != "labelled" {
+		t.Errorf("error has label %v, wanted %v", v, "labelled")
+	}
+
+	select {
+	case <-h[0].Done():
+	default:
+		t.Errorf("failed task's handle isn't done")
+	}
+	if q.Len() != 0 {
+		t.Errorf("failed tasks were returned to the queue")
+	}
+	if err := q.Wait(); err != nil {
+		t.Errorf("second Wait() = %v, wanted nil", err)
+	}
+}
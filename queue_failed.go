@@ -0,0 +1,135 @@
+package things
+
+import "sort"
+
+// failure is a task that failed since execution was last resumed.
+type failure struct {
+	seq uint64
+	id  TaskID
+	err error // with the task's position
+}
+
+// SkipFailed skips tasks that failed before execution was halted.
+// If ids are given, only failed tasks with those IDs, as set with KeyID on the errors returned by Err, are skipped.
+// Otherwise, every failed task is skipped.
+// If execution is not halted, it waits until it has. It returns the number of tasks skipped.
+func (q *Queue) SkipFailed(ids ...TaskID) int {
+	q.mutex.Lock()
+	q.waitExit()
+
+	want := make(map[TaskID]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+	seqs := make(map[uint64]bool)
+	for _, f := range q.failed {
+		if len(ids) == 0 || want[f.id] {
+			seqs[f.seq] = true
+		}
+	}
+	if seqs[q.errSeq] {
+		q.errSeq = 0
+	}
+
+	skipped := q.remove(seqs)
+	q.freed()
+	q.mutex.Unlock()
+	for i := range skipped {
+		q.done(&skipped[i], TaskSkipped, nil)
+	}
+	return len(skipped)
+}
+
+// returns the errors of failed tasks in order, or the reason execution stopped if no tasks failed.
+// mutex must be held
+func (q *Queue) halted() error {
+	if len(q.failed) == 0 {
+		return q.err()
+	}
+	sort.Slice(q.failed, func(i, j int) bool {
+		return q.failed[i].seq < q.failed[j].seq
+	})
+	errs := make(Errors, len(q.failed))
+	for i := range q.failed {
+		errs[i] = q.failed[i].err
+	}
+	return errs.Get()
+}
+
+// removes queued tasks with the given sequence numbers, returning them.
+// mutex must be held
+func (q *Queue) remove(seqs map[uint64]bool) (removed []task) {
+	if len(seqs) == 0 {
+		return nil
+	}
+	var last uint64
+	for seq := range seqs {
+		if seq > last {
+			last = seq
+		}
+	}
+
+	for _, l := range q.lanes {
+		for _, s := range l.segs[l.head:] {
+			if !s.holds(seqs) {
+				continue
+			}
+			s.expand()
+			end := s.off
+			for end < len(s.tasks) && s.tasks[end].seq <= last {
+				end++
+			}
+
+			// Shift kept tasks towards the end to close gaps, keeping their order.
+			w := end
+			for i := end - 1; i >= s.off; i-- {
+				if seqs[s.tasks[i].seq] {
+					removed = append(removed, s.tasks[i])
+					continue
+				}
+				w--
+				s.tasks[w] = s.tasks[i]
+			}
+			for i := s.off; i < w; i++ {
+				s.tasks[i] = task{}
+			}
+			l.n -= w - s.off
+			s.off = w
+		}
+	}
+	q.prune()
+
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].seq < removed[j].seq
+	})
+	if q.labels != nil {
+		q.count(removed, -1)
+	}
+	return removed
+}
+
+// reports whether the segment holds any of the tasks with the given sequence numbers.
+func (s *segment) holds(seqs map[uint64]bool) bool {
+	if s.funcs != nil {
+		for seq := range seqs {
+			if s.covers(seq) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(seqs) > s.len() {
+		for i := s.off; i < len(s.tasks); i++ {
+			if seqs[s.tasks[i].seq] {
+				return true
+			}
+		}
+		return false
+	}
+	for seq := range seqs {
+		if _, ok := s.search(seq); ok {
+			return true
+		}
+	}
+	return false
+}
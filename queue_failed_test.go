@@ -0,0 +1,76 @@
+package things
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestQueue_SkipFailed(t *testing.T) {
+	const runners = 3
+
+	q := NewQueue(nil)
+	var wg sync.WaitGroup
+	wg.Add(runners)
+	var ids []TaskID
+	for i := 0; i < runners; i++ {
+		ids = append(ids, q.DoID(TaskOptions{}, func() error {
+			wg.Done()
+			wg.Wait()
+			return errTask
+		})...)
+	}
+	var ran int
+	q.Do(func() error { ran++; return nil }, func() error { ran++; return nil })
+
+	for i := 0; i < runners; i++ {
+		go q.Run(1)
+	}
+
+	err := q.Err(true)
+	el, ok := err.(Errors)
+	if !ok || len(el) != runners {
+		t.Fatalf("Err(true) = %v, wanted %v errors", err, runners)
+	}
+	for i := range el {
+		if !ErrIs(el[i], errTask) {
+			t.Errorf("error %v is %v, wanted %v", i, el[i], errTask)
+		}
+		if v, _ := ErrGet(el[i], KeyID); v != ids[i] {
+			t.Errorf("error %v has ID %v, wanted %v", i, v, ids[i])
+		}
+	}
+
+	if n := q.SkipFailed(ids[1]); n != 1 {
+		t.Errorf("SkipFailed(%v) = %v, wanted 1", ids[1], n)
+	}
+	if n := q.SkipFailed(); n != runners-1 {
+		t.Errorf("SkipFailed() = %v, wanted %v", n, runners-1)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len() = %v after skipping, wanted 2", q.Len())
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	if ran != 2 {
+		t.Errorf("executed %v tasks, wanted 2", ran)
+	}
+}
+
+func TestQueue_SkipFailed_Keyed(t *testing.T) {
+	q := NewQueue(nil)
+	q.Do(func() error { return nil })
+	q.Reset(nil)
+
+	nop := func() error { return nil }
+	ids := q.DoID(TaskOptions{Key: "k"}, nop, func() error { return errTask })
+	if _, err := q.RunQueued(0); !ErrIs(err, errTask) {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	if v, _ := ErrGet(q.Err(false), KeyID); v != ids[1] {
+		t.Errorf("error has ID %v, wanted %v", v, ids[1])
+	}
+	if n := q.SkipFailed(ids[1]); n != 1 {
+		t.Errorf("SkipFailed(%v) = %v, wanted 1", ids[1], n)
+	}
+}
@@ -0,0 +1,98 @@
+package things
+
+// Weight sets the weight of a class of tasks, see TaskOptions.Class.
+// Runners take tasks from the classes with queued tasks of the same priority in turn,
+// taking up to the class's weight in tasks each turn, so no class can starve the others.
+// Tasks in the same class are executed in order, but tasks in different classes are not.
+// Classes have a weight of 1 by default, and weights less than 1 reset it.
+// It can be changed at any time.
+func (q *Queue) Weight(class string, w int) {
+	q.mutex.Lock()
+	if w <= 1 {
+		delete(q.weights, class)
+	} else {
+		if q.weights == nil {
+			q.weights = make(map[string]int)
+		}
+		q.weights[class] = w
+	}
+	q.mutex.Unlock()
+}
+
+// ClassLen returns the number of queued tasks in class,
+// not counting keyed tasks waiting for an earlier task with the same key.
+func (q *Queue) ClassLen(class string) int {
+	q.mutex.Lock()
+	q.drain()
+	var n int
+	for _, l := range q.lanes {
+		if l.class == class {
+			n += l.len()
+		}
+	}
+	for i := range q.recover {
+		if q.recover[i].group.class == class {
+			n++
+		}
+	}
+	q.mutex.Unlock()
+	return n
+}
+
+// fills buff from lanes of the same priority by deficit round-robin,
+// giving each lane a turn to add up to its class's weight in tasks.
+// mutex must be held
+func (q *Queue) fair(lanes []*lane, buff []task) int {
+	p := turn{lanes[0].priority, lanes[0].remote}
+	k := 0
+	for k < len(lanes) && lanes[k].class < q.turns[p] {
+		k++
+	}
+
+	var c, idle int
+	for c < len(buff) && idle < len(lanes) {
+		if k == len(lanes) {
+			k = 0
+		}
+		l := lanes[k]
+		if l.len() == 0 {
+			l.deficit = 0
+			idle++
+			k++
+			continue
+		}
+		idle = 0
+
+		if l.deficit == 0 {
+			l.deficit = 1
+			if w, ok := q.weights[l.class]; ok {
+				l.deficit = w
+			}
+		}
+		n := l.deficit
+		if n > len(buff)-c {
+			n = len(buff) - c
+		}
+		n = l.pop(buff[c : c+n])
+		c += n
+		l.deficit -= n
+		if l.len() == 0 {
+			l.deficit = 0
+		}
+		if l.deficit == 0 {
+			k++
+		}
+	}
+
+	if q.turns == nil {
+		q.turns = make(map[turn]string)
+	}
+	q.turns[p] = lanes[k%len(lanes)].class
+	return c
+}
+
+// turn identifies lanes taking turns, which share a priority and are for the same kind of runner.
+type turn struct {
+	priority int
+	remote   bool
+}
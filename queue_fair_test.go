@@ -0,0 +1,60 @@
+package things
+
+import "testing"
+
+func TestQueue_Weight(t *testing.T) {
+	// Taking fewer tasks at a time mustn't change the order.
+	for _, batch := range []int{0, 1, 2, 5} {
+		q := NewQueue(nil)
+		q.Weight("a", 3)
+
+		var order string
+		task := func(class string) func() error {
+			return func() error {
+				order += class
+				return nil
+			}
+		}
+		for i := 0; i < 8; i++ {
+			q.DoOptions(TaskOptions{Class: "a"}, task("a"))
+		}
+		for i := 0; i < 8; i++ {
+			q.DoOptions(TaskOptions{Class: "b"}, task("b"))
+		}
+		q.DoOptions(TaskOptions{Priority: 1, Class: "c"}, task("c"))
+
+		if n := q.ClassLen("a"); n != 8 {
+			t.Errorf("ClassLen(a) = %v, wanted 8", n)
+		}
+
+		for q.Len() > 0 {
+			if _, err := q.RunQueued(batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if want := "caaabaaabaabbbbbb"; order != want {
+			t.Errorf("executed %v taking %v tasks at a time, wanted %v", order, batch, want)
+		}
+	}
+}
+
+func TestQueue_Weight_Prune(t *testing.T) {
+	q := NewQueue(nil)
+	nop := func() error { return nil }
+	for i := 0; i < 100; i++ {
+		q.DoOptions(TaskOptions{Priority: i % 10, Class: string(rune('a' + i%26))}, nop, nop)
+	}
+	id := q.DoID(TaskOptions{Priority: 100, Class: "removed"}, nop)
+	q.Remove(id[0])
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Lanes and turns are dropped once their tasks are taken.
+	q.mutex.Lock()
+	lanes, turns := len(q.lanes), len(q.turns)
+	q.mutex.Unlock()
+	if lanes != 0 || turns != 0 {
+		t.Errorf("have %v lanes and %v turns after running every task, wanted none", lanes, turns)
+	}
+}
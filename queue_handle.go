@@ -0,0 +1,167 @@
+package things
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSkipped is returned by Handle.Wait if the task was removed from the Queue without being executed.
+var ErrSkipped = errors.New("task skipped")
+
+// TaskState is the execution state of a task.
+type TaskState int
+
+// Task states
+const (
+	TaskQueued    TaskState = iota // waiting in the queue
+	TaskRunning                    // being executed by a runner
+	TaskSucceeded                  // executed without error
+	TaskFailed                     // returned an error and halted the queue; it is queued to be executed again, unless the Queue continues on error
+	TaskSkipped                    // removed from the queue without being executed
+)
+
+var taskStateNames = [...]string{
+	TaskQueued:    "queued",
+	TaskRunning:   "running",
+	TaskSucceeded: "succeeded",
+	TaskFailed:    "failed",
+	TaskSkipped:   "skipped",
+}
+
+// String implements fmt.Stringer
+func (s TaskState) String() string {
+	if s < 0 || int(s) >= len(taskStateNames) {
+		return "unknown"
+	}
+	return taskStateNames[s]
+}
+
+// DoHandle adds tasks to the Queue like DoOptions, returning a Handle for each task.
+func (q *Queue) DoHandle(o TaskOptions, f ...func() error) []*Handle {
+	handles := make([]*Handle, len(f))
+	for i := range handles {
+		handles[i] = newHandle()
+	}
+	q.add(o, len(f), func(buff []task) {
+		for i := range buff {
+			buff[i].f = f[i]
+			buff[i].extend().handle = handles[i]
+			handles[i].id = TaskID(buff[i].id())
+		}
+	})
+	return handles
+}
+
+// Handle tracks the execution of a single task.
+type Handle struct {
+	id     TaskID
+	mutex  sync.Mutex
+	state  TaskState
+	err    error
+	change chan struct{} // closed and replaced when the state changes
+	done   chan struct{}
+	final  bool // done is closed
+}
+
+func newHandle() *Handle {
+	return &Handle{
+		change: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// ID returns the task's ID.
+func (h *Handle) ID() TaskID {
+	return h.id
+}
+
+// State returns the current state of the task.
+func (h *Handle) State() TaskState {
+	h.mutex.Lock()
+	s := h.state
+	h.mutex.Unlock()
+	return s
+}
+
+// Done returns a channel that is closed once the task has succeeded or been skipped.
+// A failed task is still queued, and Done is not closed until it is executed again or skipped,
+// unless the Queue continues on error, in which case it is closed when the task fails.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the task succeeds, fails or is skipped, or ctx finishes.
+// It returns nil if the task succeeded, the error that halted the queue if it failed,
+// ErrSkipped if it was skipped, or the context's error.
+// A nil context is valid.
+func (h *Handle) Wait(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for {
+		h.mutex.Lock()
+		s, err, change := h.state, h.err, h.change
+		h.mutex.Unlock()
+
+		switch s {
+		case TaskSucceeded:
+			return nil
+		case TaskFailed:
+			return err
+		case TaskSkipped:
+			return ErrSkipped
+		}
+
+		select {
+		case <-change:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (h *Handle) set(s TaskState, err error, final bool) {
+	h.mutex.Lock()
+	if h.final {
+		h.mutex.Unlock()
+		return
+	}
+	h.state, h.err, h.final = s, err, final
+	close(h.change)
+	h.change = make(chan struct{})
+	if final {
+		close(h.done)
+	}
+	h.mutex.Unlock()
+}
+
+// setState updates the task's handle if it has one.
+func (t *task) setState(s TaskState, err error) {
+	if t.ext != nil && t.ext.handle != nil {
+		t.ext.handle.set(s, err, false)
+	}
+}
+
+// notifies returns true if the task needs to be passed to done when it is removed from the queue.
+func (t *task) notifies() bool {
+	return (t.ext != nil && (t.ext.handle != nil || t.ext.onDone != nil)) || t.group.key != nil
+}
+
+// done is called once a task has succeeded or been skipped,
+// or has failed and won't be executed again.
+// mutex must not be held
+func (q *Queue) done(t *task, s TaskState, err error) {
+	if x := t.ext; x != nil {
+		if x.handle != nil {
+			x.handle.set(s, err, true)
+		}
+		if x.onDone != nil {
+			x.onDone(s)
+		}
+	}
+	if t.group.key != nil {
+		q.release(t.group.key)
+	}
+}
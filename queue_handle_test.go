@@ -0,0 +1,64 @@
+package things
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHandle(t *testing.T) {
+	q := NewQueue(nil)
+
+	block := make(chan struct{})
+	handles := q.DoHandle(TaskOptions{},
+		func() error {
+			<-block
+			return nil
+		},
+		func() error { return errTask },
+		func() error { return nil },
+	)
+
+	for _, h := range handles {
+		if s := h.State(); s != TaskQueued {
+			t.Errorf("new task has state %v, wanted %v", s, TaskQueued)
+		}
+	}
+
+	go q.RunQueued(0)
+	for handles[0].State() != TaskRunning {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	if err := handles[0].Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() on running task returned %v, wanted %v", err, context.DeadlineExceeded)
+	}
+	cancel()
+
+	close(block)
+	<-handles[0].Done()
+	if err := handles[0].Wait(nil); err != nil {
+		t.Errorf("Wait() on succeeded task returned %v", err)
+	}
+	if err := handles[1].Wait(nil); err != errTask {
+		t.Errorf("Wait() on failed task returned %v, wanted %v", err, errTask)
+	}
+
+	if err := q.Wait(); err != errTask {
+		t.Fatalf("expected %v from Wait() but got %v", errTask, err)
+	}
+	if s := handles[1].State(); s != TaskFailed {
+		t.Errorf("failed task has state %v, wanted %v", s, TaskFailed)
+	}
+
+	q.SkipErrored()
+	if err := handles[1].Wait(nil); err != ErrSkipped {
+		t.Errorf("Wait() on skipped task returned %v, wanted %v", err, ErrSkipped)
+	}
+
+	q.Reset(nil)
+	if s := handles[2].State(); s != TaskSkipped {
+		t.Errorf("task removed by Reset has state %v, wanted %v", s, TaskSkipped)
+	}
+}
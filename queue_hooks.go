@@ -0,0 +1,136 @@
+package things
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Hooks are functions called as the Queue executes tasks.
+// Any of them can be nil.
+// They are called without the Queue's mutex held, but are called by runners
+// and block them until they return.
+type Hooks struct {
+	// OnEnqueue is called after n tasks are added to the queue.
+	OnEnqueue func(n int)
+
+	// OnStart is called before a task is executed.
+	OnStart func(t TaskInfo)
+
+	// OnSuccess is called after a task succeeds, with the time it took to execute.
+	OnSuccess func(t TaskInfo, d time.Duration)
+
+	// OnError is called after a task returns an error, with the time it took to execute.
+	// It is called for every failed attempt, including those that are retried.
+	OnError func(t TaskInfo, err error, d time.Duration)
+
+	// OnHalt is called when execution halts, after all runners have stopped,
+	// with the error explaining why.
+	OnHalt func(err error)
+
+	// OnResume is called when execution is resumed after halting.
+	OnResume func()
+}
+
+// TaskInfo describes a task, and is given to Hooks.
+type TaskInfo struct {
+	Priority int
+	Attempts int           // number of previous failed attempts
+	Enqueued time.Time     // when the task was added to the queue, or zero if it was added before the Queue had hooks or metrics
+	Wait     time.Duration // how long the task was queued before it was last started, or zero if Enqueued is
+}
+
+// Hooks sets the hooks called as tasks are executed.
+// A nil Hooks removes them.
+// Changes apply to tasks taken by runners after the call.
+func (q *Queue) Hooks(h *Hooks) {
+	q.mutex.Lock()
+	q.hooks = h
+	q.direct()
+	q.mutex.Unlock()
+}
+
+// observer calls hooks and records metrics, either of which may be nil.
+type observer struct {
+	hooks   *Hooks
+	metrics *QueueMetrics
+}
+
+func (o observer) enqueue(n int) {
+	if o.metrics != nil {
+		atomic.AddUint64(&o.metrics.enqueued, uint64(n))
+	}
+	if o.hooks != nil && o.hooks.OnEnqueue != nil {
+		o.hooks.OnEnqueue(n)
+	}
+}
+
+// returns true if tasks are observed by hooks or metrics.
+func (o observer) active() bool {
+	return o.hooks != nil || o.metrics != nil
+}
+
+// start is called before t is executed, returning the start time.
+func (o observer) start(t *task) time.Time {
+	if !o.active() {
+		return time.Time{}
+	}
+
+	now := time.Now()
+	info := t.info(now)
+	if o.metrics != nil {
+		atomic.AddUint64(&o.metrics.started, 1)
+		if !info.Enqueued.IsZero() {
+			o.metrics.WaitTime.Observe(info.Wait)
+		}
+	}
+	if o.hooks != nil && o.hooks.OnStart != nil {
+		o.hooks.OnStart(info)
+	}
+	return now
+}
+
+// done is called after t is executed.
+func (o observer) done(t *task, err error, start time.Time) {
+	if !o.active() {
+		return
+	}
+
+	d := time.Since(start)
+	if o.metrics != nil {
+		o.metrics.ExecTime.Observe(d)
+		if err == nil {
+			atomic.AddUint64(&o.metrics.succeeded, 1)
+			o.metrics.throughput.add()
+		} else {
+			atomic.AddUint64(&o.metrics.failed, 1)
+		}
+	}
+
+	if o.hooks == nil {
+		return
+	}
+	if err == nil && o.hooks.OnSuccess != nil {
+		o.hooks.OnSuccess(t.info(start), d)
+	}
+	if err != nil && o.hooks.OnError != nil {
+		o.hooks.OnError(t.info(start), err, d)
+	}
+}
+
+// returns the TaskInfo of t, last started at start.
+func (t *task) info(start time.Time) TaskInfo {
+	var wait time.Duration
+	if !t.group.enqueued.IsZero() {
+		wait = start.Sub(t.group.enqueued)
+	}
+	var attempts int
+	if t.ext != nil {
+		attempts = t.ext.attempts
+	}
+	return TaskInfo{
+		Priority: t.group.priority,
+		Attempts: attempts,
+		Enqueued: t.group.enqueued,
+		Wait:     wait,
+	}
+}
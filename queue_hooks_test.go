@@ -0,0 +1,86 @@
+package things
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueue_Hooks(t *testing.T) {
+	var enqueued, started, succeeded, failed, resumed int64
+	halted := make(chan error, 1)
+
+	q := NewQueue(nil)
+	q.Hooks(&Hooks{
+		OnEnqueue: func(n int) { atomic.AddInt64(&enqueued, int64(n)) },
+		OnStart:   func(TaskInfo) { atomic.AddInt64(&started, 1) },
+		OnSuccess: func(TaskInfo, time.Duration) { atomic.AddInt64(&succeeded, 1) },
+		OnError:   func(TaskInfo, error, time.Duration) { atomic.AddInt64(&failed, 1) },
+		OnHalt: func(err error) {
+			halted <- err
+			q.Len() // hooks can call Queue methods
+		},
+		OnResume: func() { atomic.AddInt64(&resumed, 1) },
+	})
+
+	fail := true
+	q.Do(testFuncs(3)...)
+	q.Do(func() error {
+		if fail {
+			fail = false
+			return errTask
+		}
+		return nil
+	})
+
+	if _, err := q.RunQueued(0); err != errTask {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	if err := <-halted; err != errTask {
+		t.Errorf("OnHalt called with %v, wanted %v", err, errTask)
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+
+	want := []struct {
+		name      string
+		got, want int64
+	}{
+		{"OnEnqueue", enqueued, 4},
+		{"OnStart", started, 5},
+		{"OnSuccess", succeeded, 4},
+		{"OnError", failed, 1},
+		{"OnResume", resumed, 1},
+	}
+	for _, w := range want {
+		if w.got != w.want {
+			t.Errorf("%v counted %v, wanted %v", w.name, w.got, w.want)
+		}
+	}
+	num = 0
+}
+
+func TestQueue_Hooks_Enqueued(t *testing.T) {
+	q := NewQueue(nil)
+	q.Do(func() error { return nil })
+	var infos []TaskInfo
+	q.Hooks(&Hooks{
+		OnStart: func(info TaskInfo) { infos = append(infos, info) },
+	})
+	q.Do(func() error { return nil })
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+
+	// Only the task added after the hooks were set has its time recorded.
+	if len(infos) != 2 {
+		t.Fatalf("OnStart called %v times, wanted 2", len(infos))
+	}
+	if !infos[0].Enqueued.IsZero() || infos[0].Wait != 0 {
+		t.Errorf("task added before Hooks has Enqueued %v and Wait %v, wanted zero", infos[0].Enqueued, infos[0].Wait)
+	}
+	if infos[1].Enqueued.IsZero() || infos[1].Wait < 0 {
+		t.Errorf("task added after Hooks has Enqueued %v and Wait %v", infos[1].Enqueued, infos[1].Wait)
+	}
+}
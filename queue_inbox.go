@@ -0,0 +1,121 @@
+package things
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+const inboxSize = 256 // number of tasks an inbox holds
+
+// inbox holds tasks added by Do without taking the Queue's mutex, until they are moved to their lane by drain.
+// Producers reserve slots by incrementing tail, so tasks are ordered by when their slots were reserved.
+// A drained inbox is closed by setting tail to inboxSize, so producers still holding it see it as full.
+type inbox struct {
+	tail  uint64 // number of reserved slots
+	funcs [inboxSize]func() error
+	ready [inboxSize]uint32 // set once the slot's function is written, which is nil if it didn't fit
+}
+
+// adds tasks without options to the Queue, reporting false if they must be added with their options instead,
+// because the Queue is bounded or additions are observed.
+// Tasks that fit are added to the inbox, and others directly to their lane.
+func (q *Queue) send(f []func() error) bool {
+	if len(f) == 0 {
+		return true
+	}
+	if atomic.LoadInt32(&q.locked) != 0 {
+		return false
+	}
+	if b, _ := q.inbox.Load().(*inbox); b != nil && len(f) <= inboxSize {
+		n := uint64(len(f))
+		i := atomic.AddUint64(&b.tail, n) - n
+		if i+n <= inboxSize {
+			for j := range f {
+				b.funcs[i+uint64(j)] = f[j]
+				atomic.StoreUint32(&b.ready[i+uint64(j)], 1)
+			}
+			if i == 0 {
+				// The inbox was empty, so runners might be waiting. Taking the mutex ensures those that haven't
+				// seen our tasks yet are waiting on cond before it is broadcast.
+				q.mutex.Lock()
+				q.mutex.Unlock()
+				q.cond.Broadcast()
+			}
+			return true
+		}
+		// Release the slots we reserved, so drain doesn't wait for them.
+		for ; i < inboxSize; i++ {
+			atomic.StoreUint32(&b.ready[i], 1)
+		}
+	}
+
+	q.mutex.Lock()
+	if atomic.LoadInt32(&q.locked) != 0 {
+		q.mutex.Unlock()
+		return false
+	}
+	q.drain()
+	q.lane(0, "", false).add(f, q.seq+1)
+	q.seq += uint64(len(f))
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+	return true
+}
+
+// moves tasks from the inbox to the end of their lane.
+// mutex must be held
+func (q *Queue) drain() {
+	b, _ := q.inbox.Load().(*inbox)
+	if b != nil && atomic.LoadUint64(&b.tail) == 0 {
+		return
+	}
+
+	// Replace the inbox before closing it, so producers can carry on adding tasks while we drain it.
+	next := q.spare
+	if next == nil {
+		next = &inbox{}
+	}
+	q.spare = nil
+	atomic.StoreUint64(&next.tail, 0)
+	q.inbox.Store(next)
+	if b == nil {
+		return
+	}
+
+	n := atomic.SwapUint64(&b.tail, inboxSize)
+	if n > inboxSize {
+		n = inboxSize
+	}
+	funcs := b.funcs[:n]
+	c := 0
+	for i := range funcs {
+		for atomic.LoadUint32(&b.ready[i]) == 0 {
+			// A producer has reserved the slot but not written it yet.
+			runtime.Gosched()
+		}
+		b.ready[i] = 0
+		if funcs[i] != nil {
+			funcs[c] = funcs[i]
+			c++
+		}
+	}
+	if c > 0 {
+		q.lane(0, "", false).add(funcs[:c], q.seq+1)
+		q.seq += uint64(c)
+	}
+	for i := range funcs {
+		funcs[i] = nil
+	}
+	q.spare = b
+}
+
+// updates whether Do can add tasks without their options,
+// which it can't while the Queue is bounded or additions are observed by hooks or metrics.
+// mutex must be held
+func (q *Queue) direct() {
+	var locked int32
+	if q.capacity > 0 || q.hooks != nil || q.metrics != nil {
+		locked = 1
+	}
+	atomic.StoreInt32(&q.locked, locked)
+}
@@ -0,0 +1,67 @@
+package things
+
+// DoKeyed adds tasks to the Queue that are executed one at a time, in order, with other tasks with the same key.
+// Tasks with different keys are executed concurrently as usual.
+//
+// Only the first task for a key is added to the queue. Others wait until it has succeeded or been skipped,
+// when the next is added to the end of the queue. A failed task keeps its key,
+// so later tasks with the same key don't execute until it is resumed or skipped.
+// The key must be comparable.
+func (q *Queue) DoKeyed(key interface{}, f ...func() error) {
+	q.DoOptions(TaskOptions{Key: key}, f...)
+}
+
+// keyLane holds tasks waiting for an earlier task with the same key.
+type keyLane struct {
+	waiting []task
+}
+
+// adds tasks with the given key, queuing the first if no task with the key is queued or executing.
+// mutex must be held
+func (q *Queue) addKeyed(key interface{}, tasks []task) {
+	k, ok := q.keys[key]
+	if !ok {
+		if q.keys == nil {
+			q.keys = make(map[interface{}]*keyLane)
+		}
+		k = &keyLane{}
+		q.keys[key] = k
+		q.push(tasks[0])
+		tasks = tasks[1:]
+	}
+	k.waiting = append(k.waiting, tasks...)
+	q.waiting += len(tasks)
+}
+
+// release queues the next task waiting for key.
+// mutex must not be held
+func (q *Queue) release(key interface{}) {
+	q.mutex.Lock()
+	k, ok := q.keys[key]
+	if !ok {
+		q.mutex.Unlock()
+		return
+	}
+	if len(k.waiting) == 0 {
+		delete(q.keys, key)
+		q.mutex.Unlock()
+		return
+	}
+
+	q.push(k.waiting[0])
+	k.waiting[0] = task{}
+	k.waiting = k.waiting[1:]
+	q.waiting--
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+// push adds a task to the end of its lane.
+// mutex must be held
+func (q *Queue) push(t task) {
+	q.drain()
+	l := q.lane(t.group.priority, t.group.class, t.group.remote != nil)
+	q.seq++
+	t.seq = q.seq
+	l.grow(1)[0] = t
+}
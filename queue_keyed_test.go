@@ -0,0 +1,80 @@
+package things
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueue_DoKeyed(t *testing.T) {
+	const keys, perKey = 4, 25
+
+	q := NewQueue(nil)
+	var mutex sync.Mutex
+	order := make([][]int, keys)
+	active := make([]int32, keys)
+	var concurrent int32
+
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			k, i := k, i
+			q.DoKeyed(k, func() error {
+				if atomic.AddInt32(&active[k], 1) != 1 {
+					atomic.StoreInt32(&concurrent, 1)
+				}
+				time.Sleep(100 * time.Microsecond)
+				mutex.Lock()
+				order[k] = append(order[k], i)
+				mutex.Unlock()
+				atomic.AddInt32(&active[k], -1)
+				return nil
+			})
+		}
+	}
+	if l := q.Len(); l != keys*perKey {
+		t.Errorf("Len() = %v, want %v", l, keys*perKey)
+	}
+
+	for i := 0; i < 8; i++ {
+		go q.Run(0)
+	}
+	if err := q.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	q.Cancel()
+
+	if concurrent != 0 {
+		t.Errorf("tasks with the same key executed concurrently")
+	}
+	for k := range order {
+		if len(order[k]) != perKey {
+			t.Fatalf("key %v executed %v tasks, wanted %v", k, len(order[k]), perKey)
+		}
+		for i := range order[k] {
+			if order[k][i] != i {
+				t.Fatalf("key %v executed in wrong order; got %v", k, order[k])
+			}
+		}
+	}
+}
+
+func TestQueue_DoKeyedError(t *testing.T) {
+	q := NewQueue(nil)
+	var ran []string
+	q.DoKeyed("a", func() error { return errTask }, func() error { ran = append(ran, "a"); return nil })
+	q.DoKeyed("b", func() error { ran = append(ran, "b"); return nil })
+
+	if _, err := q.RunQueued(0); err != errTask {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	if q.SkipErrored(); q.Len() != 2 {
+		t.Errorf("have %v tasks after skipping, wanted %v", q.Len(), 2)
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	if len(ran) != 2 || ran[1] != "a" {
+		t.Errorf("executed %v, wanted %v", ran, []string{"b", "a"})
+	}
+}
@@ -0,0 +1,42 @@
+package things
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+)
+
+// ErrPanic is the error for a task that panicked when panics are recovered.
+var ErrPanic = errors.New("task panicked")
+
+// Keys set on ErrPanic errors.
+const (
+	// KeyPanic is the value the task panicked with.
+	KeyPanic ErrKey = "panic"
+
+	// KeyStack is the stack trace of the panicking goroutine as a string.
+	KeyStack ErrKey = "stack"
+)
+
+// RecoverPanics sets whether runners recover from panicking tasks.
+// If enabled, a panicking task fails with ErrPanic, with KeyPanic and KeyStack set, and is handled like any other failed task;
+// it is returned to the queue, and execution halts unless it is retried.
+// Otherwise, a panicking task crashes the program.
+// Changes apply to tasks taken by runners after the call.
+func (q *Queue) RecoverPanics(enabled bool) {
+	q.mutex.Lock()
+	q.catch = enabled
+	q.mutex.Unlock()
+}
+
+// catch executes the task, recovering from a panic.
+func (t *task) catch(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = ErrPanic
+			ErrSet(&err, KeyPanic, r)
+			ErrSet(&err, KeyStack, string(debug.Stack()))
+		}
+	}()
+	return t.call(ctx)
+}
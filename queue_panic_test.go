@@ -0,0 +1,42 @@
+package things
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueue_RecoverPanics(t *testing.T) {
+	q := NewQueue(nil)
+	q.RecoverPanics(true)
+
+	var ran []int
+	q.Do(
+		func() error { ran = append(ran, 0); return nil },
+		func() error { panic("oops") },
+		func() error { ran = append(ran, 1); return nil },
+	)
+
+	_, err := q.RunQueued(0)
+	if !ErrIs(err, ErrPanic) {
+		t.Fatalf("expected %v from RunQueued but got %v", ErrPanic, err)
+	}
+	if v, _ := ErrGet(err, KeyPanic); v != "oops" {
+		t.Errorf("error has panic value %v, wanted %v", v, "oops")
+	}
+	if stack, _ := ErrGet(err, KeyStack); !strings.Contains(stack.(string), "TestQueue_RecoverPanics") {
+		t.Errorf("error doesn't have the panicking stack trace; got %v", stack)
+	}
+	if q.Len() != 2 || !q.IsIdle() {
+		t.Errorf("panicking task wasn't returned to the queue cleanly")
+	}
+
+	if !q.SkipErrored() {
+		t.Errorf("SkipErrored() = false")
+	}
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	if len(ran) != 2 {
+		t.Errorf("executed %v tasks, wanted %v", len(ran), 2)
+	}
+}
@@ -0,0 +1,192 @@
+package things
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	poolInterval    = 50 * time.Millisecond // how often Start's runners are scaled
+	poolIdleTimeout = time.Second           // how long runners can be idle before being stopped
+)
+
+// pool is the set of runners managed by Start.
+type pool struct {
+	min, max  int
+	workers   int // running workers
+	idle      int // workers waiting for tasks
+	idleTicks int // consecutive intervals with idle workers
+	retire    int // workers asked to exit
+	stop      bool
+	quit      chan struct{} // closed by Stop
+	exited    chan struct{} // closed when all workers have exited after Stop
+}
+
+// Start starts at least min and at most max runners which execute tasks like Run.
+// More runners are started while tasks are waiting and no runners are idle,
+// and runners idle for longer than a second are stopped, down to min.
+// Calling Start again changes the number of runners.
+//
+// When execution halts, the runners wait until it is resumed with Resume, Run or RunQueued.
+func (q *Queue) Start(min, max int) {
+	if min < 0 {
+		min = 0
+	}
+	if max < min {
+		max = min
+	}
+	if max < 1 {
+		max = 1
+	}
+
+	q.mutex.Lock()
+	q.init()
+	p := q.pool
+	if p == nil || p.stop {
+		p = &pool{
+			quit:   make(chan struct{}),
+			exited: make(chan struct{}),
+		}
+		q.pool = p
+		go q.scaler(p)
+	}
+
+	p.min, p.max = min, max
+	if p.workers < min {
+		q.spawn(p, min-p.workers)
+	}
+	if p.workers-p.retire > max {
+		p.retire = p.workers - max
+		q.cond.Broadcast()
+	}
+	q.mutex.Unlock()
+}
+
+// Stop stops the runners started by Start, and waits for them to exit or ctx to finish.
+// Runners finish executing the tasks they have taken before exiting.
+// A nil context is valid.
+func (q *Queue) Stop(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	q.mutex.Lock()
+	p := q.pool
+	if p == nil {
+		q.mutex.Unlock()
+		return nil
+	}
+	if !p.stop {
+		p.stop = true
+		close(p.quit)
+		if p.workers == 0 {
+			close(p.exited)
+		}
+	}
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+
+	select {
+	case <-p.exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Workers returns the number of runners started by Start.
+func (q *Queue) Workers() int {
+	q.mutex.Lock()
+	n := 0
+	if q.pool != nil {
+		n = q.pool.workers
+	}
+	q.mutex.Unlock()
+	return n
+}
+
+// spawns n workers, up to the pool's maximum.
+// mutex must be held
+func (q *Queue) spawn(p *pool, n int) {
+	if n > p.max-p.workers {
+		n = p.max - p.workers
+	}
+	for ; n > 0; n-- {
+		p.workers++
+		go q.worker(p)
+	}
+}
+
+// periodically scales the pool.
+func (q *Queue) scaler(p *pool) {
+	ticker := time.NewTicker(poolInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+
+		q.mutex.Lock()
+		q.scale(p)
+		q.mutex.Unlock()
+	}
+}
+
+// mutex must be held
+func (q *Queue) scale(p *pool) {
+	if p.stop {
+		return
+	}
+
+	backlog := q.ready(false)
+	switch {
+	case p.idle == 0 && backlog > 0 && q.err() == nil:
+		p.idleTicks = 0
+		b := q.batchSize(0)
+		q.spawn(p, (backlog+b-1)/b)
+	case p.idle > 0 && p.workers-p.retire > p.min:
+		p.idleTicks++
+		if time.Duration(p.idleTicks)*poolInterval < poolIdleTimeout {
+			return
+		}
+		p.idleTicks = 0
+		n := p.workers - p.retire - p.min
+		if n > p.idle {
+			n = p.idle
+		}
+		p.retire += n
+		q.cond.Broadcast()
+	default:
+		p.idleTicks = 0
+	}
+}
+
+// executes tasks until the pool stops or asks it to exit.
+func (q *Queue) worker(p *pool) {
+	q.mutex.Lock()
+	r := q.register()
+	for {
+		p.idle++
+		for !p.stop && p.retire == 0 && (q.ready(false) == 0 || q.err() != nil) {
+			q.cond.Wait()
+		}
+		p.idle--
+
+		if p.stop || p.retire > 0 {
+			if !p.stop {
+				p.retire--
+			}
+			p.workers--
+			if p.stop && p.workers == 0 {
+				close(p.exited)
+			}
+			q.unregister(r)
+			q.mutex.Unlock()
+			return
+		}
+
+		q.run(r, 0)
+	}
+}
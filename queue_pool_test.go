@@ -0,0 +1,53 @@
+package things
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue_Start(t *testing.T) {
+	q := NewQueue(nil)
+
+	release := make(chan struct{})
+	funcs := make([]func() error, buffSize*4)
+	for i := range funcs {
+		funcs[i] = func() error {
+			<-release
+			return nil
+		}
+	}
+
+	q.Start(1, 4)
+	q.Do(funcs...)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Workers() != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("runners didn't scale up; have %v but wanted %v", q.Workers(), 4)
+		}
+		time.Sleep(poolInterval)
+	}
+
+	close(release)
+	if err := q.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+
+	deadline = time.Now().Add(poolIdleTimeout + 5*time.Second)
+	for q.Workers() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("runners didn't scale down; have %v but wanted %v", q.Workers(), 1)
+		}
+		time.Sleep(poolInterval)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.Stop(ctx); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	if n := q.Workers(); n != 0 {
+		t.Errorf("%v runners still running after Stop", n)
+	}
+}
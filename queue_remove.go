@@ -0,0 +1,97 @@
+package things
+
+// TaskID identifies a task added to a Queue.
+// IDs are unique within a Queue, and increase in the order tasks are added.
+type TaskID uint64
+
+// DoID adds tasks to the Queue like DoOptions, returning the ID of each task for use with Remove.
+func (q *Queue) DoID(o TaskOptions, f ...func() error) []TaskID {
+	ids := make([]TaskID, len(f))
+	q.add(o, len(f), func(buff []task) {
+		for i := range buff {
+			buff[i].f = f[i]
+			ids[i] = TaskID(buff[i].id())
+		}
+	})
+	return ids
+}
+
+// Remove removes a task that hasn't started executing from the Queue, including
+// tasks returned to the queue after execution halted and keyed tasks waiting for their key.
+// The task is skipped, as with SkipErrored, and the order of other tasks is unchanged.
+// It returns false if the task isn't queued, such as if it is executing or has already been executed or skipped.
+func (q *Queue) Remove(id TaskID) bool {
+	q.mutex.Lock()
+	t, ok := q.take(uint64(id))
+	if !ok {
+		q.mutex.Unlock()
+		return false
+	}
+	if t.group.label != "" {
+		q.count([]task{t}, -1)
+	}
+	if t.seq != 0 && t.seq == q.errSeq {
+		q.errSeq = 0
+	}
+	q.freed()
+	q.mutex.Unlock()
+	q.done(&t, TaskSkipped, nil)
+	return true
+}
+
+// removes the queued task with the given id.
+// mutex must be held
+func (q *Queue) take(id uint64) (task, bool) {
+	for _, l := range q.lanes {
+		for _, s := range l.segs[l.head:] {
+			if s.funcs != nil {
+				// A plain task's ID is its sequence number.
+				if !s.covers(id) {
+					continue
+				}
+				s.expand()
+			}
+			for i := s.off; i < len(s.tasks); i++ {
+				if s.tasks[i].id() == id {
+					t := s.tasks[i]
+					copy(s.tasks[s.off+1:i+1], s.tasks[s.off:i])
+					s.tasks[s.off] = task{}
+					s.off++
+					l.n--
+					q.prune()
+					return t, true
+				}
+			}
+		}
+	}
+
+	for i := range q.recover {
+		if q.recover[i].id() == id {
+			t := q.recover[i]
+			last := len(q.recover) - 1
+			copy(q.recover[i:], q.recover[i+1:])
+			q.recover[last] = task{}
+			q.recover = q.recover[:last]
+			return t, true
+		}
+	}
+
+	for _, k := range q.keys {
+		for i := range k.waiting {
+			if k.waiting[i].id() == id {
+				t := k.waiting[i]
+				last := len(k.waiting) - 1
+				copy(k.waiting[i:], k.waiting[i+1:])
+				k.waiting[last] = task{}
+				k.waiting = k.waiting[:last]
+				q.waiting--
+				// It never held the key, so mustn't release it.
+				g := *t.group
+				g.key = nil
+				t.group = &g
+				return t, true
+			}
+		}
+	}
+	return task{}, false
+}
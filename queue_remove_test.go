@@ -0,0 +1,51 @@
+package things
+
+import "testing"
+
+func TestQueue_Remove(t *testing.T) {
+	q := NewQueue(nil)
+
+	var order []int
+	task := func(n int) func() error {
+		return func() error {
+			order = append(order, n)
+			return nil
+		}
+	}
+
+	ids := q.DoID(TaskOptions{}, task(0), task(1), task(2))
+	keyed := q.DoID(TaskOptions{Key: "key"}, task(3), task(4), task(5))
+	h := q.DoHandle(TaskOptions{}, task(6))
+
+	if !q.Remove(ids[1]) {
+		t.Errorf("Remove() = false for queued task")
+	}
+	if q.Remove(ids[1]) {
+		t.Errorf("Remove() = true for removed task")
+	}
+	if !q.Remove(keyed[1]) {
+		t.Errorf("Remove() = false for waiting keyed task")
+	}
+	if !q.Remove(h[0].ID()) {
+		t.Errorf("Remove() = false for task with handle")
+	}
+	if err := h[0].Wait(nil); err != ErrSkipped {
+		t.Errorf("removed task's handle returned %v, wanted %v", err, ErrSkipped)
+	}
+
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, 2, 3, 5}
+	if len(order) != len(want) {
+		t.Fatalf("executed %v, wanted %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("executed %v, wanted %v", order, want)
+		}
+	}
+	if q.Remove(ids[0]) {
+		t.Errorf("Remove() = true for executed task")
+	}
+}
@@ -0,0 +1,128 @@
+package things
+
+import (
+	"context"
+	"sync"
+)
+
+// Result is the outcome of a task added to a ResultQueue.
+type Result[T any] struct {
+	// Value is the value returned by the task if it succeeded.
+	Value T
+
+	// Err is ErrSkipped if the task was skipped,
+	// or the error it returned if it failed while the Queue continues on error.
+	Err error
+}
+
+// NewResultQueue creates a ResultQueue that executes tasks on q.
+func NewResultQueue[T any](q *Queue) *ResultQueue[T] {
+	return &ResultQueue[T]{
+		queue:  q,
+		ready:  make(map[uint64]Result[T]),
+		change: make(chan struct{}),
+	}
+}
+
+// ResultQueue adds tasks that return a value to a Queue, and delivers their results in the order they were added,
+// regardless of the order runners complete them in.
+//
+// A task that fails and halts the Queue is executed again once it is resumed, as with any other task,
+// and its result is delivered once it succeeds or is skipped.
+// Results are held until they are read, so they should be read even if they aren't needed.
+type ResultQueue[T any] struct {
+	queue *Queue
+	added uint64 // number of tasks added; queue mutex must be held
+
+	mutex  sync.Mutex
+	next   uint64 // index of the next result to deliver
+	ready  map[uint64]Result[T]
+	change chan struct{} // closed and replaced when a result is ready
+}
+
+// Do adds tasks to the Queue.
+func (rq *ResultQueue[T]) Do(f ...func() (T, error)) {
+	rq.queue.add(TaskOptions{}, len(f), func(buff []task) {
+		for i := range buff {
+			r := &Result[T]{}
+			index, f := rq.added, f[i]
+			rq.added++
+
+			buff[i].f = func() error {
+				v, err := f()
+				r.Value, r.Err = v, err
+				return err
+			}
+			buff[i].extend().onDone = func(s TaskState) {
+				if s == TaskSkipped {
+					r.Err = ErrSkipped
+				}
+				rq.deliver(index, *r)
+			}
+		}
+	})
+}
+
+// Next returns the next result, waiting until the task has been executed or ctx finishes.
+// A nil context is valid.
+func (rq *ResultQueue[T]) Next(ctx context.Context) (Result[T], error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	for {
+		rq.mutex.Lock()
+		r, ok := rq.ready[rq.next]
+		if ok {
+			delete(rq.ready, rq.next)
+			rq.next++
+			rq.mutex.Unlock()
+			return r, nil
+		}
+		change := rq.change
+		rq.mutex.Unlock()
+
+		select {
+		case <-change:
+		case <-ctx.Done():
+			return Result[T]{}, ctx.Err()
+		}
+	}
+}
+
+// Results returns a channel delivering results in order, as with Next.
+// The channel is closed once ctx finishes, and it mustn't be used at the same time as Next or another call to Results.
+// A nil context is valid.
+func (rq *ResultQueue[T]) Results(ctx context.Context) <-chan Result[T] {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	c := make(chan Result[T])
+	go func() {
+		defer close(c)
+		for {
+			r, err := rq.Next(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case c <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// stores the result of the task with the given index.
+func (rq *ResultQueue[T]) deliver(index uint64, r Result[T]) {
+	rq.mutex.Lock()
+	rq.ready[index] = r
+	if index == rq.next {
+		close(rq.change)
+		rq.change = make(chan struct{})
+	}
+	rq.mutex.Unlock()
+}
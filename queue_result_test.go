@@ -0,0 +1,66 @@
+package things
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResultQueue(t *testing.T) {
+	const tasks = 100
+
+	q := NewQueue(nil)
+	rq := NewResultQueue[int](q)
+	for i := 0; i < tasks; i++ {
+		i := i
+		rq.Do(func() (int, error) {
+			time.Sleep(time.Duration(tasks-i) * time.Microsecond)
+			return i, nil
+		})
+	}
+	for i := 0; i < 4; i++ {
+		go func() {
+			// Take one task at a time so they complete out of order.
+			for q.Run(1) == nil {
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < tasks; i++ {
+		r, err := rq.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next() returned %v after %v results", err, i)
+		}
+		if r.Value != i || r.Err != nil {
+			t.Fatalf("result %v is %+v, wanted %v", i, r, i)
+		}
+	}
+	q.Cancel()
+}
+
+func TestResultQueue_Skipped(t *testing.T) {
+	q := NewQueue(nil)
+	rq := NewResultQueue[string](q)
+	rq.Do(
+		func() (string, error) { return "", errTask },
+		func() (string, error) { return "b", nil },
+	)
+
+	if _, err := q.RunQueued(0); err != errTask {
+		t.Fatalf("RunQueued(0) = %v, wanted %v", err, errTask)
+	}
+	q.SkipErrored()
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+
+	results := rq.Results(nil)
+	if r := <-results; r.Err != ErrSkipped {
+		t.Errorf("skipped task's result is %+v, wanted %v", r, ErrSkipped)
+	}
+	if r := <-results; r.Value != "b" || r.Err != nil {
+		t.Errorf("result is %+v, wanted %v", r, "b")
+	}
+}
@@ -0,0 +1,106 @@
+package things
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// KeyAttempts is set on errors from tasks that were retried before halting the Queue.
+// Its value is the number of times the task was attempted.
+const KeyAttempts ErrKey = "attempts"
+
+// RetryPolicy describes how a failed task is retried before it halts the Queue.
+// Retries happen in the runner that executed the task, so tasks remain in order.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times a task is attempted.
+	// Zero or one disables retrying.
+	Attempts int
+
+	// Backoff is the delay before the first retry.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between retries if not zero.
+	MaxBackoff time.Duration
+
+	// Multiplier is applied to the delay for each subsequent retry.
+	// Values less than one default to two.
+	Multiplier float64
+
+	// Jitter is the fraction of the delay that is randomised, from 0 to 1.
+	Jitter float64
+
+	// If is called with the task's error, and the task is only retried if it returns true.
+	// A nil If retries all errors.
+	If func(error) bool
+}
+
+// Delay returns the delay before the given retry, starting from 1.
+func (p *RetryPolicy) Delay(retry int) time.Duration {
+	m := p.Multiplier
+	if m < 1 {
+		m = 2
+	}
+
+	d := float64(p.Backoff)
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || d < float64(p.MaxBackoff)); i++ {
+		d *= m
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d -= d * p.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Retry sets the retry policy for tasks that weren't given one.
+// A nil policy disables retrying.
+// Changes apply to tasks taken by runners after the call.
+func (q *Queue) Retry(p *RetryPolicy) {
+	q.mutex.Lock()
+	q.retry = p
+	q.mutex.Unlock()
+}
+
+// DoRetry adds tasks to the Queue that are retried according to p.
+func (q *Queue) DoRetry(p *RetryPolicy, f ...func() error) {
+	q.DoOptions(TaskOptions{Retry: p}, f...)
+}
+
+// wait records a failed attempt, and returns true after the backoff delay if the task should be retried.
+// If ctx finishes while waiting, it returns true early.
+func (t *task) wait(ctx context.Context, def *RetryPolicy, err error) bool {
+	x := t.extend()
+	x.attempts++
+
+	p := t.group.retry
+	if p == nil {
+		p = def
+	}
+	if p == nil || x.attempts >= p.Attempts || (p.If != nil && !p.If(err)) {
+		return false
+	}
+
+	timer := time.NewTimer(p.Delay(x.attempts))
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	return true
+}
+
+// fail sets the number of attempts on err if the task was retried,
+// and resets them so the task gets another set of attempts if execution is resumed.
+func (t *task) fail(err *error) {
+	if t.ext == nil {
+		return
+	}
+	if t.ext.attempts > 1 {
+		ErrSet(err, KeyAttempts, t.ext.attempts)
+	}
+	t.ext.attempts = 0
+}
@@ -0,0 +1,99 @@
+package things
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueRetry(t *testing.T) {
+	type args struct {
+		policy *RetryPolicy
+		fails  int
+	}
+	tests := []struct {
+		name     string
+		args     args
+		attempts int
+		wantErr  bool
+	}{
+		{
+			name: "no policy",
+			args: args{
+				fails: 1,
+			},
+			attempts: 1,
+			wantErr:  true,
+		},
+		{
+			name: "recovers",
+			args: args{
+				policy: &RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+				fails:  2,
+			},
+			attempts: 3,
+		},
+		{
+			name: "exhausted",
+			args: args{
+				policy: &RetryPolicy{Attempts: 2, Backoff: time.Millisecond, Jitter: 0.5},
+				fails:  5,
+			},
+			attempts: 2,
+			wantErr:  true,
+		},
+		{
+			name: "not retryable",
+			args: args{
+				policy: &RetryPolicy{Attempts: 3, If: func(err error) bool { return err != errTask }},
+				fails:  2,
+			},
+			attempts: 1,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(nil)
+			var attempts int
+			q.DoRetry(tt.args.policy, func() error {
+				attempts++
+				if attempts <= tt.args.fails {
+					return errTask
+				}
+				return nil
+			})
+
+			_, err := q.RunQueued(0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunQueued() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("task attempted %v times, wanted %v", attempts, tt.attempts)
+			}
+			if err == nil {
+				return
+			}
+			if !ErrIs(err, errTask) {
+				t.Errorf("error %v doesn't wrap %v", err, errTask)
+			}
+			if n, ok := ErrGet(err, KeyAttempts); tt.attempts > 1 && n != tt.attempts {
+				t.Errorf("error has %v attempts, wanted %v", n, tt.attempts)
+			} else if tt.attempts == 1 && ok {
+				t.Errorf("error has attempts set for a task that wasn't retried")
+			}
+			if q.Len() != 1 {
+				t.Errorf("failed task wasn't returned to the queue")
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i := range want {
+		if got := p.Delay(i + 1); got != want[i] {
+			t.Errorf("Delay(%v) = %v, want %v", i+1, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package things
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// DoAt adds tasks to the Queue at time t.
+// The returned function removes the tasks from the schedule,
+// returning false if they had already been added to the queue.
+//
+// Scheduled tasks are kept by a single goroutine shared by all of the Queue's schedules,
+// and are added to the queue with Do when they are due, regardless of whether execution is halted.
+// Reset removes all schedules.
+func (q *Queue) DoAt(t time.Time, f ...func() error) (stop func() bool) {
+	return q.schedule(&scheduled{at: t, f: f})
+}
+
+// DoAfter adds tasks to the Queue after the duration d.
+// See DoAt.
+func (q *Queue) DoAfter(d time.Duration, f ...func() error) (stop func() bool) {
+	return q.DoAt(time.Now().Add(d), f...)
+}
+
+// DoEvery adds tasks to the Queue every period d, starting after d.
+// If the schedule falls behind, occurrences are skipped rather than added all at once.
+// The returned function stops the schedule, returning false if it was already stopped.
+// See DoAt.
+func (q *Queue) DoEvery(d time.Duration, f ...func() error) (stop func() bool) {
+	if d <= 0 {
+		panic("non-positive interval for Queue.DoEvery")
+	}
+	return q.schedule(&scheduled{at: time.Now().Add(d), every: d, f: f})
+}
+
+// DoCron adds tasks to the Queue at times matching the cron expression expr, as parsed by ParseCron,
+// in the local time zone. The returned function stops the schedule, returning false if it was already stopped.
+// See DoAt.
+func (q *Queue) DoCron(expr string, f ...func() error) (stop func() bool, err error) {
+	c, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
+	}
+	at := c.Next(time.Now())
+	if at.IsZero() {
+		return func() bool { return false }, nil
+	}
+	return q.schedule(&scheduled{at: at, cron: c, f: f}), nil
+}
+
+// scheduler adds tasks to a Queue when they are due.
+type scheduler struct {
+	mutex   sync.Mutex
+	entries schedule
+	seq     uint64
+	wake    chan struct{}
+	running bool // the scheduling goroutine is running
+}
+
+// scheduled is a set of tasks waiting to be added to the queue.
+type scheduled struct {
+	at    time.Time
+	f     []func() error
+	every time.Duration
+	cron  *Cron
+	seq   uint64
+	index int // index in schedule, or -1 if not scheduled
+}
+
+// next returns the time e should be added again after now, or false if it doesn't recur.
+func (e *scheduled) next(now time.Time) (time.Time, bool) {
+	switch {
+	case e.every > 0:
+		at := e.at.Add(e.every)
+		if !at.After(now) {
+			at = now.Add(e.every)
+		}
+		return at, true
+	case e.cron != nil:
+		at := e.cron.Next(now)
+		return at, !at.IsZero()
+	default:
+		return time.Time{}, false
+	}
+}
+
+func (q *Queue) schedule(e *scheduled) (stop func() bool) {
+	q.mutex.Lock()
+	if q.sched == nil {
+		q.sched = &scheduler{wake: make(chan struct{}, 1)}
+	}
+	s := q.sched
+	q.mutex.Unlock()
+
+	s.mutex.Lock()
+	s.seq++
+	e.seq = s.seq
+	heap.Push(&s.entries, e)
+	if !s.running {
+		s.running = true
+		go q.scheduler(s)
+	}
+	s.mutex.Unlock()
+	s.notify()
+
+	return func() bool {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if e.index < 0 {
+			return false
+		}
+		heap.Remove(&s.entries, e.index)
+		return true
+	}
+}
+
+// clear removes all schedules.
+func (s *scheduler) clear() {
+	s.mutex.Lock()
+	for _, e := range s.entries {
+		e.index = -1
+	}
+	s.entries = nil
+	s.mutex.Unlock()
+	s.notify()
+}
+
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// adds scheduled tasks to the queue as they are due, returning when there are no more schedules.
+func (q *Queue) scheduler(s *scheduler) {
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	for {
+		s.mutex.Lock()
+		now := time.Now()
+		var due []func() error
+		for len(s.entries) > 0 && !s.entries[0].at.After(now) {
+			e := s.entries[0]
+			due = append(due, e.f...)
+			if at, ok := e.next(now); ok {
+				e.at = at
+				heap.Fix(&s.entries, 0)
+			} else {
+				heap.Pop(&s.entries)
+			}
+		}
+
+		if len(s.entries) == 0 {
+			s.running = false
+			s.mutex.Unlock()
+			if len(due) > 0 {
+				q.Do(due...)
+			}
+			return
+		}
+		wait := s.entries[0].at.Sub(now)
+		s.mutex.Unlock()
+
+		if len(due) > 0 {
+			q.Do(due...)
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// schedule is a min-heap of scheduled tasks, ordered by time and then scheduling order.
+type schedule []*scheduled
+
+func (s schedule) Len() int { return len(s) }
+
+func (s schedule) Less(i, j int) bool {
+	if s[i].at.Equal(s[j].at) {
+		return s[i].seq < s[j].seq
+	}
+	return s[i].at.Before(s[j].at)
+}
+
+func (s schedule) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	s[i].index = i
+	s[j].index = j
+}
+
+func (s *schedule) Push(x interface{}) {
+	e := x.(*scheduled)
+	e.index = len(*s)
+	*s = append(*s, e)
+}
+
+func (s *schedule) Pop() interface{} {
+	old := *s
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	e.index = -1
+	*s = old[:len(old)-1]
+	return e
+}
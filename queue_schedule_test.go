@@ -0,0 +1,67 @@
+package things
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueue_DoAt(t *testing.T) {
+	q := NewQueue(nil)
+
+	var mutex sync.Mutex
+	var ran []int
+	task := func(n int) func() error {
+		return func() error {
+			mutex.Lock()
+			ran = append(ran, n)
+			mutex.Unlock()
+			return nil
+		}
+	}
+
+	now := time.Now()
+	q.DoAt(now.Add(40*time.Millisecond), task(2))
+	q.DoAfter(20*time.Millisecond, task(1))
+	stop := q.DoAfter(30*time.Millisecond, task(-1))
+	q.DoAt(now.Add(-time.Second), task(0))
+	every := q.DoEvery(25*time.Millisecond, task(3))
+
+	if !stop() {
+		t.Errorf("stop() = false for a pending task")
+	}
+	go q.Run(0)
+
+	time.Sleep(90 * time.Millisecond)
+	if !every() {
+		t.Errorf("stop() = false for a recurring schedule")
+	}
+	q.Wait()
+
+	mutex.Lock()
+	if len(ran) < 4 || ran[0] != 0 || ran[1] != 1 {
+		t.Errorf("tasks executed in wrong order; got %v", ran)
+	}
+	var first, recurring int
+	for _, n := range ran {
+		switch n {
+		case 2:
+			first++
+		case 3:
+			recurring++
+		case -1:
+			t.Errorf("stopped task was executed")
+		}
+	}
+	if first != 1 || recurring < 2 {
+		t.Errorf("wrong tasks executed; got %v", ran)
+	}
+	mutex.Unlock()
+
+	q.DoAfter(10*time.Millisecond, task(-1))
+	q.Reset(nil)
+	time.Sleep(30 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("Reset didn't clear schedules")
+	}
+}
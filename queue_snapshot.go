@@ -0,0 +1,204 @@
+package things
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const historySize = 16 // number of halts kept for Snapshot
+
+// RunnerState is the state of a runner.
+type RunnerState int
+
+// Runner states
+const (
+	RunnerWaiting   RunnerState = iota // waiting for tasks, or for execution to be resumed
+	RunnerExecuting                    // executing a batch of tasks
+)
+
+var runnerStateNames = [...]string{
+	RunnerWaiting:   "waiting",
+	RunnerExecuting: "executing",
+}
+
+// String implements fmt.Stringer
+func (s RunnerState) String() string {
+	if s < 0 || int(s) >= len(runnerStateNames) {
+		return "unknown"
+	}
+	return runnerStateNames[s]
+}
+
+// Snapshot is the state of a Queue at a point in time, returned by Queue.Snapshot.
+type Snapshot struct {
+	// Pending is the number of tasks waiting to be executed,
+	// including keyed tasks waiting for their key and tasks being returned by halting runners.
+	Pending int
+
+	// Labels is the number of pending tasks with each label. Tasks without a label aren't counted.
+	Labels map[string]int
+
+	// Runners are the calls to Run and RunQueued, and the runners started by Start, in the order they began.
+	Runners []RunnerSnapshot
+
+	// Err is the error execution halted with, as returned by Queue.Err, or nil if it hasn't halted.
+	Err error
+
+	// Errored is the task that produced the error, if it is still queued. See SkipErrored.
+	Errored *TaskSnapshot
+
+	// History is the most recent halts, oldest first.
+	History []Halt
+}
+
+// RunnerSnapshot is the state of a runner.
+type RunnerSnapshot struct {
+	State RunnerState
+
+	// Task is the task being executed, or nil if the runner is waiting.
+	Task *TaskSnapshot
+
+	// Batch is the number of tasks the runner has taken from the queue and not yet executed, including Task.
+	Batch int
+}
+
+// TaskSnapshot describes a task.
+type TaskSnapshot struct {
+	ID       TaskID
+	Label    string
+	Priority int
+	Enqueued time.Time // zero unless the Queue had hooks or metrics when the task was added
+}
+
+// Halt is a time execution halted, and the error it halted with.
+type Halt struct {
+	Time time.Time
+	Err  error
+}
+
+// Snapshot returns the current state of the Queue.
+// It doesn't wait for runners or copy queued tasks, though finding Errored searches the queue, so its cost grows slowly with the number of queued tasks.
+func (q *Queue) Snapshot() Snapshot {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	s := Snapshot{
+		Pending: q.len() + q.waiting + len(q.recover),
+		Runners: make([]RunnerSnapshot, len(q.runners)),
+		History: append([]Halt(nil), q.history...),
+	}
+	if len(q.labels) > 0 {
+		s.Labels = make(map[string]int, len(q.labels))
+		for l, n := range q.labels {
+			s.Labels[l] = n
+		}
+	}
+
+	for i, r := range q.runners {
+		if len(r.batch) == 0 {
+			continue
+		}
+		cur := int(atomic.LoadInt32(&r.current))
+		t := r.batch[cur].snapshot()
+		s.Runners[i] = RunnerSnapshot{
+			State: RunnerExecuting,
+			Task:  &t,
+			Batch: len(r.batch) - cur,
+		}
+	}
+
+	if q.err() != nil {
+		s.Err = q.halted()
+	}
+	if t, ok := q.find(q.errSeq); ok {
+		ts := t.snapshot()
+		s.Errored = &ts
+	}
+	return s
+}
+
+// runner is a call to Run or RunQueued, or a pool worker.
+type runner struct {
+	buff    []task      // buffer for batches. Guarded by the Queue's mutex.
+	batch   []task      // tasks taken by the runner, or nil if it is waiting. Guarded by the Queue's mutex.
+	current int32       // index in batch of the executing task, accessed atomically
+	remote  *remoteConn // worker executing the runner's tasks, if not nil
+}
+
+// mutex must be held
+func (q *Queue) register() *runner {
+	r := &runner{}
+	q.runners = append(q.runners, r)
+	return r
+}
+
+// mutex must be held
+func (q *Queue) unregister(r *runner) {
+	for i := range q.runners {
+		if q.runners[i] == r {
+			copy(q.runners[i:], q.runners[i+1:])
+			q.runners[len(q.runners)-1] = nil
+			q.runners = q.runners[:len(q.runners)-1]
+			return
+		}
+	}
+}
+
+// adds d to the label counts of tasks.
+// mutex must be held
+func (q *Queue) count(tasks []task, d int) {
+	for i := range tasks {
+		l := tasks[i].group.label
+		if l == "" {
+			continue
+		}
+		if q.labels == nil {
+			q.labels = make(map[string]int)
+		}
+		q.labels[l] += d
+		if q.labels[l] <= 0 {
+			delete(q.labels, l)
+		}
+	}
+}
+
+// records a halt for Snapshot.
+// mutex must be held
+func (q *Queue) remember(err error) {
+	if len(q.history) == historySize {
+		copy(q.history, q.history[1:])
+		q.history = q.history[:historySize-1]
+	}
+	q.history = append(q.history, Halt{time.Now(), err})
+}
+
+// returns the queued task with sequence number seq.
+// mutex must be held
+func (q *Queue) find(seq uint64) (*task, bool) {
+	if seq == 0 {
+		return nil, false
+	}
+	for _, l := range q.lanes {
+		for _, s := range l.segs[l.head:] {
+			if s.funcs != nil {
+				if !s.covers(seq) {
+					continue
+				}
+				s.expand()
+			}
+			if i, ok := s.search(seq); ok {
+				return &s.tasks[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (t *task) snapshot() TaskSnapshot {
+	return TaskSnapshot{
+		ID:       TaskID(t.id()),
+		Label:    t.group.label,
+		Priority: t.group.priority,
+		Enqueued: t.group.enqueued,
+	}
+}
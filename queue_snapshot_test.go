@@ -0,0 +1,59 @@
+package things
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueue_Snapshot(t *testing.T) {
+	q := NewQueue(nil)
+	started, release := make(chan struct{}), make(chan struct{})
+	q.DoOptions(TaskOptions{Label: "block"}, func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	q.DoOptions(TaskOptions{Label: "fail"}, func() error { return errTask })
+	q.DoOptions(TaskOptions{Label: "after"}, func() error { return nil }, func() error { return nil })
+
+	s := q.Snapshot()
+	if s.Pending != 4 || s.Labels["after"] != 2 || len(s.Runners) != 0 || s.Err != nil {
+		t.Fatalf("unexpected snapshot of new queue %+v", s)
+	}
+
+	ec := make(chan error)
+	go func() {
+		_, err := q.RunQueued(0)
+		ec <- err
+	}()
+	<-started
+
+	s = q.Snapshot()
+	if len(s.Runners) != 1 || s.Runners[0].State != RunnerExecuting {
+		t.Fatalf("snapshot has runners %+v, wanted one executing", s.Runners)
+	}
+	if task := s.Runners[0].Task; task == nil || task.Label != "block" || s.Runners[0].Batch != 4 {
+		t.Errorf("runner is executing %+v with batch %v, wanted task %v with batch 4", task, s.Runners[0].Batch, "block")
+	}
+	if s.Pending != 0 || s.Labels != nil {
+		t.Errorf("snapshot has %v pending tasks with labels %v, wanted none", s.Pending, s.Labels)
+	}
+
+	close(release)
+	select {
+	case <-ec:
+	case <-time.After(time.Second):
+		t.Fatal("runner didn't halt")
+	}
+
+	s = q.Snapshot()
+	if !ErrIs(s.Err, errTask) || len(s.Runners) != 0 || s.Pending != 3 || s.Labels["fail"] != 1 {
+		t.Errorf("unexpected snapshot of halted queue %+v", s)
+	}
+	if s.Errored == nil || s.Errored.Label != "fail" {
+		t.Errorf("snapshot has errored task %+v, wanted %v", s.Errored, "fail")
+	}
+	if len(s.History) != 1 || !ErrIs(s.History[0].Err, errTask) {
+		t.Errorf("snapshot has history %v, wanted one halt", s.History)
+	}
+}
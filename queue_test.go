@@ -1,9 +1,11 @@
 package things
 
 import (
+	"context"
 	"errors"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var num uint64
@@ -31,6 +33,40 @@ func BenchmarkQueue(b *testing.B) {
 	q.Cancel()
 }
 
+// Many producers adding single tasks while many runners execute them.
+func BenchmarkQueue_Contention(b *testing.B) {
+	q := NewQueue(nil)
+	for i := 0; i < 32; i++ {
+		go q.Run(0)
+	}
+
+	nop := func() error { return nil }
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			q.Do(nop)
+		}
+	})
+	q.Wait()
+	b.StopTimer()
+	q.Cancel()
+}
+
+// Halting with a large queue, returning a batch of tasks to the front of it each time.
+func BenchmarkQueue_Halt(b *testing.B) {
+	q := NewQueue(nil)
+	q.Do(testFuncs(100000)...)
+	fail := func() error { return errTask }
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.DoPriority(1, fail)
+		q.RunQueued(0)
+		q.SkipErrored()
+		q.Resume()
+	}
+}
+
 var errTask = errors.New("task error")
 
 func TestQueue(t *testing.T) {
@@ -83,7 +119,7 @@ func TestQueue(t *testing.T) {
 		t.Errorf("not all functions were ran! Queued %v but only ran %v", testNum*batchSize, num)
 	}
 
-	if err := <-ec; err != errTask {
+	if err := <-ec; !ErrIs(err, errTask) {
 		t.Errorf("expected %v from error channel but got %v", errTask, err)
 	}
 
@@ -94,3 +130,172 @@ func TestQueue(t *testing.T) {
 
 	num = 0
 }
+
+func TestQueuePriority(t *testing.T) {
+	queue := NewQueue(nil)
+
+	var order []int
+	task := func(n int, err error) func() error {
+		return func() error {
+			if err == nil {
+				order = append(order, n)
+			}
+			return err
+		}
+	}
+
+	queue.DoPriority(-1, task(4, nil), task(5, nil))
+	queue.Do(task(2, nil), task(3, nil))
+	queue.DoPriority(1, task(0, nil), task(-1, errTask), task(1, nil))
+
+	if _, err := queue.RunQueued(0); err != errTask {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	if queue.Len() != 6 {
+		t.Errorf("expected unexecuted tasks to be returned; have %v tasks but wanted %v", queue.Len(), 6)
+	}
+	if !queue.SkipErrored() {
+		t.Errorf("SkipErrored() = false")
+	}
+	if _, err := queue.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+
+	for i := range order {
+		if order[i] != i {
+			t.Fatalf("tasks executed in wrong order; got %v", order)
+		}
+	}
+	if len(order) != 6 {
+		t.Errorf("expected %v tasks to execute but %v did", 6, len(order))
+	}
+}
+
+func TestQueue_FinishAfterHalt(t *testing.T) {
+	queue := NewQueue(nil)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	queue.Do(func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	queue.Do(func() error { return errTask })
+
+	ec := make(chan error)
+	go func() {
+		ec <- queue.Run(1)
+	}()
+	<-started
+
+	// Halt execution while the first runner is still executing its task.
+	if _, err := queue.RunQueued(1); err != errTask {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	close(release)
+	if err := <-ec; err != nil {
+		t.Fatalf("error from Run %v", err)
+	}
+
+	// The last runner to leave returns the failed task and records the halt.
+	if queue.Len() != 1 {
+		t.Errorf("have %v tasks after halting, wanted %v", queue.Len(), 1)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- queue.Err(true)
+	}()
+	select {
+	case err := <-errc:
+		if !ErrIs(err, errTask) {
+			t.Errorf("expected %v from Err but got %v", errTask, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Err(true) didn't return after the last runner left")
+	}
+}
+
+func TestQueue_DoCtx(t *testing.T) {
+	queue := NewQueue(nil)
+
+	started := make(chan struct{})
+	var runs int
+	queue.DoCtx(func(ctx context.Context) error {
+		runs++
+		if runs == 1 {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	ec := make(chan error)
+	go func() {
+		ec <- queue.Run(0)
+	}()
+
+	<-started
+	queue.Cancel()
+	if err := <-ec; err != context.Canceled {
+		t.Errorf("expected %v from Run but got %v", context.Canceled, err)
+	}
+	if queue.Len() != 1 {
+		t.Errorf("interrupted task wasn't returned to the queue")
+	}
+
+	if _, err := queue.RunQueued(0); err != nil {
+		t.Errorf("error running tasks %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("task ran %v times, wanted %v", runs, 2)
+	}
+}
+
+func TestQueue_Segments(t *testing.T) {
+	queue := NewQueue(nil)
+
+	var order []int
+	failed := false
+	task := func(n int) func() error {
+		return func() error {
+			if n == segmentSize+10 && !failed {
+				failed = true
+				return errTask
+			}
+			order = append(order, n)
+			return nil
+		}
+	}
+
+	// Tasks added singly, and in a batch larger than a segment.
+	n := 0
+	for ; n < segmentSize*2; n++ {
+		queue.Do(task(n))
+	}
+	batch := make([]func() error, segmentSize*3)
+	for i := range batch {
+		batch[i] = task(n)
+		n++
+	}
+	queue.Do(batch...)
+
+	if _, err := queue.RunQueued(0); err != errTask {
+		t.Fatalf("expected %v from RunQueued but got %v", errTask, err)
+	}
+	if want := n - (segmentSize + 10); queue.Len() != want {
+		t.Fatalf("have %v tasks after halting, wanted %v", queue.Len(), want)
+	}
+	if _, err := queue.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+
+	if len(order) != n {
+		t.Fatalf("executed %v tasks, wanted %v", len(order), n)
+	}
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("task %v executed at position %v", v, i)
+		}
+	}
+}
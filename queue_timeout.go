@@ -0,0 +1,63 @@
+package things
+
+import (
+	"context"
+	"time"
+)
+
+// KeyTimeout is the timeout of a task that took too long, set on its error.
+const KeyTimeout ErrKey = "timeout"
+
+// DoWithTimeout adds context-aware tasks to the Queue, each of which must return within d.
+// A task's context is cancelled once d has elapsed, and if it then returns an error,
+// it fails with an error wrapping context.DeadlineExceeded and the task's own error,
+// with KeyTimeout and KeyLabel set. It is handled like any other failed task.
+// See DoCtx.
+func (q *Queue) DoWithTimeout(d time.Duration, f ...func(context.Context) error) {
+	q.DoOptionsCtx(TaskOptions{Timeout: d}, f...)
+}
+
+// Timeout sets the default timeout for context-aware tasks, used for tasks that don't set their own.
+// A timeout of 0 or less removes the default.
+// Tasks that aren't context-aware can't be interrupted, so timeouts don't apply to them.
+// Changes apply to tasks taken by runners after the call.
+func (q *Queue) Timeout(d time.Duration) {
+	q.mutex.Lock()
+	q.timeout = d
+	q.mutex.Unlock()
+}
+
+// exec executes the task within its timeout, or def if it doesn't have one.
+// If catch is true, panics are recovered.
+func (t *task) exec(ctx context.Context, catch bool, def time.Duration) error {
+	d := t.group.timeout
+	if d == 0 {
+		d = def
+	}
+	if t.fctx() == nil || d <= 0 {
+		return t.invoke(ctx, catch)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	err := t.invoke(tctx, catch)
+	if err != nil && ctx.Err() == nil && tctx.Err() == context.DeadlineExceeded {
+		task := err
+		err = context.DeadlineExceeded
+		if task != context.DeadlineExceeded {
+			ErrAdd(&err, task)
+		}
+		ErrSet(&err, KeyTimeout, d)
+		if t.group.label != "" {
+			ErrSet(&err, KeyLabel, t.group.label)
+		}
+	}
+	return err
+}
+
+func (t *task) invoke(ctx context.Context, catch bool) error {
+	if catch {
+		return t.catch(ctx)
+	}
+	return t.call(ctx)
+}
@@ -0,0 +1,40 @@
+package things
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue_DoWithTimeout(t *testing.T) {
+	q := NewQueue(nil)
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	q.DoWithTimeout(time.Second, func(context.Context) error { return nil })
+	q.DoOptionsCtx(TaskOptions{Label: "hang", Timeout: 10 * time.Millisecond}, hang)
+
+	n, err := q.RunQueued(0)
+	if n != 1 || !ErrIs(err, context.DeadlineExceeded) {
+		t.Fatalf("RunQueued(0) = %v, %v; wanted 1, %v", n, err, context.DeadlineExceeded)
+	}
+	if v, _ := ErrGet(err, KeyTimeout); v != 10*time.Millisecond {
+		t.Errorf("error has timeout %v, wanted %v", v, 10*time.Millisecond)
+	}
+	if v, _ := ErrGet(err, KeyLabel); v != "hang" {
+		t.Errorf("error has label %v, wanted %v", v, "hang")
+	}
+	if q.Len() != 1 {
+		t.Errorf("timed out task wasn't returned to the queue")
+	}
+
+	// The default timeout applies to tasks without their own.
+	q.Reset(nil)
+	q.Timeout(10 * time.Millisecond)
+	q.DoCtx(hang)
+	if _, err := q.RunQueued(0); !ErrIs(err, context.DeadlineExceeded) {
+		t.Errorf("RunQueued(0) = %v with default timeout, wanted %v", err, context.DeadlineExceeded)
+	}
+}
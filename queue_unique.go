@@ -0,0 +1,93 @@
+package things
+
+import "time"
+
+// uniqueTask is a task added with DoUnique.
+type uniqueTask struct {
+	key    interface{}
+	handle *Handle
+	done   time.Time // when the task succeeded, or zero if it hasn't
+}
+
+// DoUnique adds a task identified by key to the Queue, unless a task with the same key is already queued or executing,
+// or succeeded within the window set by UniqueWindow. key must be comparable.
+// It returns the Handle of the task with the key, and whether the task was added;
+// if it wasn't, f is dropped and the Handle is that of the existing task.
+// If the Queue is full, it waits for space, or for a task with the same key to be added.
+//
+// A key is forgotten once its task is skipped, or fails without being executed again, so that it can be retried.
+// Unlike TaskOptions.Key, which orders tasks sharing a key, key identifies a single task.
+func (q *Queue) DoUnique(key interface{}, f func() error) (*Handle, bool) {
+	q.mutex.Lock()
+	for {
+		q.expire(time.Now())
+		if u, ok := q.unique[key]; ok {
+			q.mutex.Unlock()
+			return u.handle, false
+		}
+		if q.fits(1) {
+			break
+		}
+		// Another task with the key may be added while waiting for space.
+		q.init()
+		q.blocked++
+		q.cond.Wait()
+		q.blocked--
+	}
+
+	// The key is registered along with the task, so the Handle has its ID before it's shared.
+	u := &uniqueTask{
+		key:    key,
+		handle: newHandle(),
+	}
+	q.put(TaskOptions{}, 1, func(buff []task) {
+		buff[0].f = f
+		buff[0].extend().handle = u.handle
+		buff[0].ext.onDone = func(s TaskState) {
+			q.mutex.Lock()
+			q.forget(u, s)
+			q.mutex.Unlock()
+		}
+		u.handle.id = TaskID(buff[0].id())
+		if q.unique == nil {
+			q.unique = make(map[interface{}]*uniqueTask)
+		}
+		q.unique[key] = u
+	})
+	return u.handle, true
+}
+
+// UniqueWindow sets how long the keys of tasks added with DoUnique are remembered after they succeed,
+// during which tasks with the same key are dropped. A window of 0 or less forgets keys as soon as their task succeeds.
+func (q *Queue) UniqueWindow(d time.Duration) {
+	q.mutex.Lock()
+	q.uniqueWindow = d
+	q.expire(time.Now())
+	q.mutex.Unlock()
+}
+
+// records that a unique task is done.
+// mutex must be held
+func (q *Queue) forget(u *uniqueTask, s TaskState) {
+	if s == TaskSucceeded && q.uniqueWindow > 0 {
+		u.done = time.Now()
+		q.uniqueDone = append(q.uniqueDone, u)
+		return
+	}
+	if q.unique[u.key] == u {
+		delete(q.unique, u.key)
+	}
+}
+
+// forgets keys of tasks that succeeded before the window.
+// mutex must be held
+func (q *Queue) expire(now time.Time) {
+	for len(q.uniqueDone) > 0 && now.Sub(q.uniqueDone[0].done) >= q.uniqueWindow {
+		u := q.uniqueDone[0]
+		if q.unique[u.key] == u {
+			delete(q.unique, u.key)
+		}
+		q.uniqueDone[0] = nil
+		q.uniqueDone = q.uniqueDone[1:]
+	}
+}
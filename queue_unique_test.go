@@ -0,0 +1,83 @@
+package things
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueue_DoUnique(t *testing.T) {
+	q := NewQueue(nil)
+	var ran int
+	f := func() error { ran++; return nil }
+
+	h, added := q.DoUnique("a", f)
+	if !added {
+		t.Fatal("first task with key wasn't added")
+	}
+	if h2, added := q.DoUnique("a", f); added || h2 != h {
+		t.Errorf("queued key wasn't coalesced into the queued task")
+	}
+	if _, added := q.DoUnique("b", f); !added {
+		t.Errorf("task with a different key wasn't added")
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len() = %v, wanted 2", q.Len())
+	}
+
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatal(err)
+	}
+	if h.State() != TaskSucceeded || ran != 2 {
+		t.Fatalf("task state %v after running %v tasks, wanted %v after 2", h.State(), ran, TaskSucceeded)
+	}
+	if _, added := q.DoUnique("a", f); !added {
+		t.Errorf("succeeded key was remembered without a window")
+	}
+	q.RunQueued(0)
+
+	// Remembered within the window.
+	q.UniqueWindow(time.Hour)
+	h, _ = q.DoUnique("c", f)
+	q.RunQueued(0)
+	if h2, added := q.DoUnique("c", f); added || h2 != h {
+		t.Errorf("succeeded key was forgotten within the window")
+	}
+	q.UniqueWindow(0)
+	if _, added := q.DoUnique("c", f); !added {
+		t.Errorf("key wasn't forgotten after the window was removed")
+	}
+
+	// Skipped tasks are forgotten.
+	h, _ = q.DoUnique("d", f)
+	q.Remove(h.ID())
+	if _, added := q.DoUnique("d", f); !added {
+		t.Errorf("skipped key was remembered")
+	}
+}
+
+func TestQueue_DoUnique_Concurrent(t *testing.T) {
+	q := NewQueue(nil)
+	q.Capacity(1)
+	q.Do(func() error { return nil })
+
+	// Callers wait for space, then share the task added by the first of them.
+	const callers = 8
+	handles := make(chan *Handle, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			h, _ := q.DoUnique("a", func() error { return nil })
+			handles <- h
+		}()
+	}
+	go q.Run(1)
+
+	h := <-handles
+	for i := 1; i < callers; i++ {
+		if h2 := <-handles; h2 != h || h2.ID() != h.ID() {
+			t.Fatalf("callers got different tasks %v and %v", h.ID(), h2.ID())
+		}
+	}
+	if h.ID() == 0 {
+		t.Errorf("ID() = 0, wanted the task's ID")
+	}
+}
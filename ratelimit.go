@@ -0,0 +1,89 @@
+package things
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limit limits the rate tasks are started at to rate tasks per second, allowing bursts of up to burst tasks,
+// regardless of the number of runners. Retried tasks are limited like any other.
+// A rate of zero or less removes the limit.
+// The limit can be changed at any time, though runners already waiting to start a task are not affected.
+func (q *Queue) Limit(rate float64, burst int) {
+	q.mutex.Lock()
+	if q.limit == nil {
+		q.limit = &limiter{}
+	}
+	q.limit.set(rate, burst)
+	q.mutex.Unlock()
+}
+
+// limiter is a token bucket.
+type limiter struct {
+	mutex  sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) set(rate float64, burst int) {
+	l.mutex.Lock()
+	if burst < 1 {
+		burst = 1
+	}
+	if l.rate <= 0 {
+		// Start with a full bucket.
+		l.tokens = float64(burst)
+		l.last = time.Now()
+	}
+	l.rate, l.burst = rate, float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.mutex.Unlock()
+}
+
+// wait waits until a token is available and takes it, or returns ctx's error.
+func (l *limiter) wait(ctx context.Context) error {
+	d := l.reserve()
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		l.mutex.Lock()
+		l.tokens++
+		l.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, returning how long to wait before it is available.
+func (l *limiter) reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
@@ -0,0 +1,45 @@
+package things
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueue_Limit(t *testing.T) {
+	q := NewQueue(nil)
+	q.Limit(100, 5)
+	q.Do(testFuncs(25)...)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		go q.Run(0)
+	}
+	if err := q.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	// 5 tasks in the first burst, then 20 at 100 per second.
+	if d := time.Since(start); d < 190*time.Millisecond {
+		t.Errorf("tasks executed too quickly; took %v", d)
+	}
+	q.Cancel()
+	num = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q = NewQueue(ctx)
+	q.Limit(1, 1)
+	q.Do(testFuncs(3)...)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := q.RunQueued(0); err != context.Canceled {
+		t.Errorf("expected %v from RunQueued but got %v", context.Canceled, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("waiting for the limit didn't stop with the context; took %v", d)
+	}
+	if q.Len() != 2 {
+		t.Errorf("have %v tasks in the queue, wanted %v", q.Len(), 2)
+	}
+	num = 0
+}
@@ -0,0 +1,369 @@
+package things
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Errors returned by Server and remote tasks.
+var (
+	ErrServerClosed = errors.New("server closed")
+)
+
+var (
+	errDisconnected   = errors.New("worker disconnected")
+	errRemoteProtocol = errors.New("unexpected message from worker")
+)
+
+// message types
+const (
+	remoteReady  byte = iota + 1 // worker is ready for batches of up to N tasks
+	remoteLease                  // tasks for the worker to execute in order
+	remoteDone                   // the next task of the lease succeeded
+	remoteFailed                 // the next task of the lease failed with Err
+)
+
+// remoteMessage is a message between a Server and a Worker.
+type remoteMessage struct {
+	Type     byte
+	N        int
+	Tasks    []remoteTask
+	Continue bool // keep executing the lease after a task fails
+	Err      string
+}
+
+// remoteTask is a named task with arguments, executed by a Worker.
+type remoteTask struct {
+	Name string
+	Args []byte
+}
+
+// NewServer creates a Server serving tasks from q.
+func NewServer(q *Queue) *Server {
+	return &Server{
+		queue:     q,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Server serves named tasks from a Queue to Workers in other processes, over connections such as TCP or Unix sockets.
+//
+// Each connected worker is a runner on the Queue. It leases a batch of tasks, executes them in order and reports
+// the result of each, so failures halt execution and return tasks to the queue as they would for a local runner.
+// Workers can't be interrupted, so they finish their batch when execution halts.
+// If a worker disconnects, the tasks it hadn't reported are returned to the queue in order,
+// so a task that succeeded just before a worker disconnected will be executed again.
+// Retry policies and rate limits don't apply to tasks executed by workers.
+//
+// Tasks added with Server.Do are only executed by workers, and other tasks only by local runners,
+// so RunQueued returns when no local tasks are left, leaving the tasks for workers queued.
+type Server struct {
+	queue     *Queue
+	mutex     sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// Do adds the task with the given name and arguments to the Queue.
+// The task must be registered by the workers, otherwise it fails with ErrUnknownTask.
+func (s *Server) Do(name string, args []byte) {
+	s.queue.add(TaskOptions{remote: &remoteTask{Name: name, Args: args}}, 1, func(buff []task) {})
+}
+
+// Serve accepts connections from workers on l and serves them, until l or the Server is closed.
+// It returns ErrServerClosed if the Server was closed, otherwise the error accepting a connection.
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.listeners, l)
+		s.mutex.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a worker connected with conn until it disconnects or the Server is closed, and closes conn.
+// It returns an error if the worker doesn't follow the protocol,
+// such as by sending a result when none of its leased tasks are waiting for one, in which case it is disconnected.
+func (s *Server) ServeConn(conn net.Conn) error {
+	defer conn.Close()
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	s.conns[conn] = struct{}{}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	dec := gob.NewDecoder(conn)
+	var m remoteMessage
+	if err := dec.Decode(&m); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	if m.Type != remoteReady || m.N < 1 {
+		return errRemoteProtocol
+	}
+	n := m.N
+	if n > buffSize {
+		n = buffSize
+	}
+
+	q := s.queue
+	rc := &remoteConn{
+		enc:     gob.NewEncoder(conn),
+		results: make(chan remoteMessage, n),
+	}
+	go rc.read(q, conn, dec)
+
+	q.mutex.Lock()
+	q.init()
+	r := q.register()
+	r.remote = rc
+	for {
+		for !rc.closed && (q.ready(true) == 0 || q.err() != nil) {
+			q.cond.Wait()
+		}
+		if rc.closed {
+			break
+		}
+		if _, err := q.run(r, n); err == errDisconnected {
+			break
+		}
+	}
+	q.unregister(r)
+	err := rc.err
+	q.mutex.Unlock()
+	return err
+}
+
+// Close stops the Server's listeners and disconnects its workers.
+// Tasks leased by the workers are returned to the queue.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if lerr := l.Close(); err == nil {
+			err = lerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+// remoteConn is the Server's end of a connection to a worker.
+type remoteConn struct {
+	enc     *gob.Encoder
+	results chan remoteMessage // closed when the connection is
+	closed  bool               // Queue mutex must be held
+	err     error              // protocol error the connection was closed with; Queue mutex must be held
+	leased  []string           // names of the leased tasks
+	next    int                // index in leased of the task the next result is for
+
+	mutex   sync.Mutex
+	pending int  // leased tasks the worker hasn't sent a result for
+	cont    bool // the worker keeps executing the lease after a task fails
+}
+
+// sends tasks to the worker.
+func (rc *remoteConn) lease(tasks []task, cont bool) error {
+	m := remoteMessage{
+		Type:     remoteLease,
+		Tasks:    make([]remoteTask, len(tasks)),
+		Continue: cont,
+	}
+	rc.leased, rc.next = rc.leased[:0], 0
+	for i := range tasks {
+		m.Tasks[i] = *tasks[i].group.remote
+		rc.leased = append(rc.leased, m.Tasks[i].Name)
+	}
+	rc.mutex.Lock()
+	rc.pending, rc.cont = len(tasks), cont
+	rc.mutex.Unlock()
+	if err := rc.enc.Encode(&m); err != nil {
+		return errDisconnected
+	}
+	return nil
+}
+
+// waits for the result of the next leased task.
+func (rc *remoteConn) result() error {
+	m, ok := <-rc.results
+	if !ok {
+		return errDisconnected
+	}
+	name := rc.leased[rc.next]
+	rc.next++
+	if m.Type == remoteDone {
+		return nil
+	}
+	err := errors.New(m.Err)
+	ErrSet(&err, KeyTask, name)
+	return err
+}
+
+// reads results from the worker until the connection closes, closing it if the worker doesn't follow the protocol.
+func (rc *remoteConn) read(q *Queue, conn net.Conn, dec *gob.Decoder) {
+	var err error
+	for {
+		var m remoteMessage
+		if dec.Decode(&m) != nil {
+			break
+		}
+		if !rc.expected(m) {
+			err = errRemoteProtocol
+			conn.Close()
+			break
+		}
+		rc.results <- m
+	}
+	close(rc.results)
+
+	q.mutex.Lock()
+	rc.closed, rc.err = true, err
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+// reports whether m is the result of a leased task that hasn't had one.
+func (rc *remoteConn) expected(m remoteMessage) bool {
+	if m.Type != remoteDone && m.Type != remoteFailed {
+		return false
+	}
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+	if rc.pending == 0 {
+		return false
+	}
+	rc.pending--
+	// The worker stops executing the lease after a failure unless it continues on error.
+	if m.Type == remoteFailed && !rc.cont {
+		rc.pending = 0
+	}
+	return true
+}
+
+// NewWorker creates a Worker with no registered tasks.
+func NewWorker() *Worker {
+	return &Worker{
+		funcs: make(map[string]func([]byte) error),
+	}
+}
+
+// Worker executes named tasks served by a Server.
+type Worker struct {
+	mutex sync.Mutex
+	funcs map[string]func([]byte) error
+}
+
+// Register registers f as the task with the given name.
+func (w *Worker) Register(name string, f func(args []byte) error) {
+	w.mutex.Lock()
+	w.funcs[name] = f
+	w.mutex.Unlock()
+}
+
+// Serve executes tasks from the Server connected with conn, leasing up to batch tasks at a time,
+// until ctx finishes or the connection is closed, and closes conn.
+// When ctx finishes, the task being executed is completed, and the rest of the batch is returned to the Server's queue.
+// It returns nil if the Server closed the connection, or the context's error.
+// A nil context is valid.
+func (w *Worker) Serve(ctx context.Context, conn net.Conn, batch int) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if batch < 1 {
+		batch = 1
+	}
+
+	defer conn.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	err := enc.Encode(&remoteMessage{Type: remoteReady, N: batch})
+	for err == nil {
+		var m remoteMessage
+		if err = dec.Decode(&m); err != nil {
+			break
+		}
+		for _, t := range m.Tasks {
+			if ctx.Err() != nil {
+				break
+			}
+			reply := remoteMessage{Type: remoteDone}
+			terr := w.exec(t)
+			if terr != nil {
+				reply = remoteMessage{Type: remoteFailed, Err: terr.Error()}
+			}
+			if err = enc.Encode(&reply); err != nil || (terr != nil && !m.Continue) {
+				break
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// executes a task.
+func (w *Worker) exec(t remoteTask) error {
+	w.mutex.Lock()
+	f, ok := w.funcs[t.Name]
+	w.mutex.Unlock()
+	if !ok {
+		return ErrUnknownTask
+	}
+	return f(t.Args)
+}
@@ -0,0 +1,259 @@
+package things
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "queue.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewQueue(nil)
+	s := NewServer(q)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	for i := 0; i < 6; i++ {
+		s.Do("append", []byte{byte(i)})
+	}
+
+	// A worker that acknowledges one task of its batch and disconnects.
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+	var m remoteMessage
+	if err := enc.Encode(&remoteMessage{Type: remoteReady, N: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Tasks) != 3 || m.Tasks[0].Args[0] != 0 {
+		t.Fatalf("leased %v, wanted the first 3 tasks", m.Tasks)
+	}
+	if err := enc.Encode(&remoteMessage{Type: remoteDone}); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// Wait for the unacknowledged tasks to be returned.
+	for deadline := time.Now().Add(time.Second); q.Len() != 5; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %v after disconnect, wanted 5", q.Len())
+		}
+	}
+
+	var mutex sync.Mutex
+	var got []byte
+	errFail := errors.New("fail")
+	w := NewWorker()
+	w.Register("append", func(args []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		got = append(got, args...)
+		if args[0] == 4 {
+			return errFail
+		}
+		return nil
+	})
+
+	conn, err = net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	worked := make(chan error, 1)
+	go func() { worked <- w.Serve(ctx, conn, 2) }()
+
+	err = q.Err(true)
+	if err == nil || err.Error() != errFail.Error() {
+		t.Fatalf("Err(true) = %v, wanted %v", err, errFail)
+	}
+	if v, _ := ErrGet(err, KeyTask); v != "append" {
+		t.Errorf("error has task %v, wanted %v", v, "append")
+	}
+
+	mutex.Lock()
+	if string(got) != "\x01\x02\x03\x04" {
+		t.Errorf("worker executed %v, wanted the unacknowledged tasks in order", got)
+	}
+	mutex.Unlock()
+	if q.Len() != 2 {
+		t.Errorf("Len() = %v after failure, wanted 2", q.Len())
+	}
+
+	cancel()
+	if err := <-worked; err != context.Canceled {
+		t.Errorf("Worker.Serve() = %v, wanted %v", err, context.Canceled)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != ErrServerClosed {
+			t.Errorf("Serve() = %v, wanted %v", err, ErrServerClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return after Close")
+	}
+}
+
+func TestServer_Local(t *testing.T) {
+	q := NewQueue(nil)
+	s := NewServer(q)
+	s.Do("remote", nil)
+	var ran int
+	q.Do(func() error { ran++; return nil })
+
+	// Local runners leave tasks for workers queued.
+	if _, err := q.RunQueued(0); err != nil {
+		t.Fatalf("error running tasks %v", err)
+	}
+	if ran != 1 || q.Len() != 1 {
+		t.Fatalf("ran %v local tasks leaving %v queued, wanted 1 and 1", ran, q.Len())
+	}
+
+	// Workers leave local tasks queued.
+	q.Do(func() error { ran++; return nil })
+	client, server := net.Pipe()
+	go s.ServeConn(server)
+	w := NewWorker()
+	remote := make(chan struct{})
+	w.Register("remote", func([]byte) error {
+		close(remote)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	worked := make(chan error, 1)
+	go func() { worked <- w.Serve(ctx, client, 2) }()
+	<-remote
+	for deadline := time.Now().Add(time.Second); q.Len() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %v after the worker ran its task, wanted 1", q.Len())
+		}
+	}
+	cancel()
+	<-worked
+	if ran != 1 {
+		t.Errorf("ran %v local tasks, wanted 1", ran)
+	}
+}
+
+// A worker disconnecting, followed by another halting execution, leaves the returned tasks in order.
+func TestServer_DisconnectHalt(t *testing.T) {
+	q := NewQueue(nil)
+	s := NewServer(q)
+	for i := 0; i < 6; i++ {
+		s.Do("task", []byte{byte(i)})
+	}
+
+	lease := func() (*gob.Encoder, net.Conn) {
+		client, server := net.Pipe()
+		go s.ServeConn(server)
+		enc, dec := gob.NewEncoder(client), gob.NewDecoder(client)
+		var m remoteMessage
+		if err := enc.Encode(&remoteMessage{Type: remoteReady, N: 3}); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Tasks) != 3 {
+			t.Fatalf("leased %v tasks, wanted 3", len(m.Tasks))
+		}
+		return enc, client
+	}
+	_, first := lease()
+	enc, second := lease()
+	defer second.Close()
+
+	first.Close()
+	for deadline := time.Now().Add(time.Second); q.Len() != 3; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %v after disconnect, wanted 3", q.Len())
+		}
+	}
+	if err := enc.Encode(&remoteMessage{Type: remoteFailed, Err: "fail"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Err(true); err == nil {
+		t.Fatal("Err(true) = nil, wanted the task's error")
+	}
+	for deadline := time.Now().Add(time.Second); q.Len() != 6; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %v after halting, wanted 6", q.Len())
+		}
+	}
+
+	q.mutex.Lock()
+	var seqs []uint64
+	for _, l := range q.lanes {
+		for _, sg := range l.segs[l.head:] {
+			for _, t := range sg.tasks[sg.off:] {
+				seqs = append(seqs, t.seq)
+			}
+		}
+	}
+	q.mutex.Unlock()
+	for i, seq := range seqs {
+		if seq != uint64(i+1) {
+			t.Fatalf("queued tasks %v, wanted them in order", seqs)
+		}
+	}
+	if !q.SkipErrored() {
+		t.Errorf("SkipErrored() = false")
+	}
+}
+
+func TestServer_UnexpectedResult(t *testing.T) {
+	q := NewQueue(nil)
+	s := NewServer(q)
+	s.Do("task", nil)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	served := make(chan error, 1)
+	go func() { served <- s.ServeConn(server) }()
+
+	enc, dec := gob.NewEncoder(client), gob.NewDecoder(client)
+	var m remoteMessage
+	if err := enc.Encode(&remoteMessage{Type: remoteReady, N: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second result, with no leased task waiting for it, disconnects the worker.
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode(&remoteMessage{Type: remoteDone}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-served:
+		if err != errRemoteProtocol {
+			t.Errorf("ServeConn() = %v, wanted %v", err, errRemoteProtocol)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeConn didn't return after an unexpected result")
+	}
+
+	// The worker is gone, so a new task isn't completed by a stale result.
+	s.Do("task", nil)
+	if q.Len() != 1 {
+		t.Errorf("Len() = %v, wanted 1", q.Len())
+	}
+}